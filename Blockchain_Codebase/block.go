@@ -3,10 +3,10 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,35 +19,55 @@ import (
 type Block struct {
 	Index        int           `json:"index"`
 	Timestamp    string        `json:"timestamp"`
+	HLC          HLC           `json:"hlc"` // Hybrid logical clock when the block was opened
 	ContainerID  string        `json:"container_id"`
 	Transactions []Transaction `json:"transactions"`
 	PreviousHash string        `json:"previous_hash"`
+	MerkleRoot   string        `json:"merkle_root"`
 	Hash         string        `json:"hash"`
 	Version      int           `json:"version"`
 	ShardID      int           `json:"shard_id"`
+	Sealed       bool          `json:"sealed"`       // No more transactions may be added
+	StateHeight  int           `json:"state_height"` // World state height when sealed
+	Usage        ResourceUsage `json:"usage"`        // Total of the transactions' usage
 }
 
 // Define Transaction structure
 type Transaction struct {
-	ContainerID   string  `json:"container_id"`
-	Timestamp     string  `json:"timestamp"`
-	TransactionID string  `json:"transaction_id"`
-	Source        int     `json:"source"`
-	Target        int     `json:"target"`
-	Version       int     `json:"version"`
-	Data          string  `json:"data"`
-	Status        string  `json:"status"`
-	Type          string  `json:"type"`
-	ExecTime      float64 `json:"execTime"`
-	Finality      float64 `json:"finalityTime"`
-	Propagation   float64 `json:"propagationLatency"`
+	ContainerID   string          `json:"container_id"`
+	Timestamp     string          `json:"timestamp"`
+	TransactionID string          `json:"transaction_id"`
+	HLC           HLC             `json:"hlc"` // Hybrid logical clock when recorded in the ledger
+	TxOrigin                      // Nonce and submitter the ID was derived from
+	Source        int             `json:"source"`
+	Target        int             `json:"target"`
+	Version       int             `json:"version"`
+	DebitVersion  int             `json:"debit_version,omitempty"` // Height of the debit in receipts mode, where Version is the credit's
+	Data          string          `json:"data"`
+	Status        string          `json:"status"`
+	Type          string          `json:"type"`
+	ExecTime      float64         `json:"execTime"`
+	Finality      float64         `json:"finalityTime"`
+	Propagation   float64         `json:"propagationLatency"`
+	ReadSet       []ReadEntry     `json:"read_set,omitempty"`
+	WriteSet      []WriteEntry    `json:"write_set,omitempty"`
+	Ops           []CommutativeOp `json:"ops,omitempty"`
+	Priority      int             `json:"priority"`
+	Attempts      int             `json:"attempts"`
+	Outcome       string          `json:"outcome"`
+	Usage         ResourceUsage   `json:"usage"`
+	SignerKeyID   string          `json:"signer_key_id,omitempty"`
+	Signature     string          `json:"signature,omitempty"` // Base64 Ed25519 over signingPayload
 }
 
 type ShardedTransaction struct {
-	Source []int  `json:"source"`
-	Target []int  `json:"target"`
-	Data   string `json:"data"`
-	Type   string `json:"type"`
+	Source      []int  `json:"source"`
+	Target      []int  `json:"target"`
+	Data        string `json:"data"`
+	Type        string `json:"type"`
+	SignerKeyID string `json:"signer_key_id,omitempty"` // A signed entry has one source and one target
+	Signature   string `json:"signature,omitempty"`
+	TxOrigin
 }
 
 // Handling concurrency
@@ -60,24 +80,24 @@ type TransactionSegment struct {
 }
 
 const (
-	NumShards               = 2 // Shard 0 for Org1, Shard 1 for Org2
 	maxTransactionsPerBlock = 3 // Limit per block
 	maxRetryAttempts        = 3 // Retry attempts for conflicts
 )
 
 // Global variables
 var (
-	concurrencyConflicts []string
+	NumShards            = 2 // Set from the genesis shards
+	concurrencyConflicts []ConflictRecord
 	conflictsMu          sync.Mutex
 	Blockchain           []Block
 	BlockchainMu         sync.Mutex
 	transactionMu        sync.Mutex
 )
 
-// Function to calculate hash for a block
-func calculateHash(index int, timestamp string, transactions []Transaction, previousHash string) string {
-	txData, _ := json.Marshal(transactions)
-	record := fmt.Sprintf("%d%s%s%s", index, timestamp, txData, previousHash)
+// Function to calculate hash for a block. The Merkle root commits to the
+// block's transactions.
+func calculateHash(index int, timestamp string, clock HLC, merkleRoot string, previousHash string) string {
+	record := fmt.Sprintf("%d%s%s%s%s", index, timestamp, clock, merkleRoot, previousHash)
 	hash := sha256.Sum256([]byte(record))
 	return hex.EncodeToString(hash[:])
 }
@@ -93,13 +113,12 @@ func getShardID(containerID interface{}) int {
 		return 0 // Default to shard 0 if ID is invalid
 	}
 
-	// Assign explicit shards for specific organizations
-	if strings.HasPrefix(containerStr, "org1") {
-		log.Printf("✅ Assigned '%s' to Shard 0 (Org1)", containerStr)
-		return 0 // Shard 0 for Org1
-	} else if strings.HasPrefix(containerStr, "org2") {
-		log.Printf("✅ Assigned '%s' to Shard 1 (Org2)", containerStr)
-		return 1
+	// Assign explicit shards for the organizations' container prefixes
+	for _, org := range orgs {
+		if strings.HasPrefix(containerStr, org.Prefix) {
+			log.Printf("✅ Assigned '%s' to Shard %d (%s)", containerStr, org.Shard, org.Name)
+			return org.Shard
+		}
 	}
 	if numID, err := strconv.Atoi(containerStr); err == nil {
 		shard := numID % NumShards
@@ -112,40 +131,84 @@ func getShardID(containerID interface{}) int {
 	return shard
 }
 
+// Find a block by its index. Caller must hold BlockchainMu.
+func findBlockByIndex(index int) *Block {
+	for i := range Blockchain {
+		if Blockchain[i].Index == index {
+			return &Blockchain[i]
+		}
+	}
+	return nil
+}
+
 func addBlock(containerID string) {
 	BlockchainMu.Lock()
 	defer BlockchainMu.Unlock()
+	appendBlock(containerID, getShardID(containerID)) // Assign correct shard
+}
 
+// Seal the current tip and open a new block after it. Caller must hold
+// BlockchainMu.
+func appendBlock(containerID string, shardID int) *Block {
 	var previousHash string
 	var version int
 
-	if len(Blockchain) > 0 {
-		previousHash = Blockchain[len(Blockchain)-1].Hash
-		version = Blockchain[len(Blockchain)-1].Version + 1
-	} else {
-		previousHash = "0" // Genesis block
-		version = 1
+	if len(Blockchain) == 0 {
+		installGenesis()
 	}
+	sealTip("new block")
+	previousHash = Blockchain[len(Blockchain)-1].Hash
+	version = Blockchain[len(Blockchain)-1].Version + 1
 
 	timestamp := time.Now().Format(time.RFC3339)
-	shardID := getShardID(containerID) // Assign correct shard
+	clock := hybridClock.Now()
 
 	newBlock := Block{
 		Index:        len(Blockchain),
 		Timestamp:    timestamp,
+		HLC:          clock,
 		ContainerID:  containerID,
 		Transactions: make([]Transaction, 0), // Ensure it's initialized
 		PreviousHash: previousHash,
-		Hash:         calculateHash(len(Blockchain), timestamp, []Transaction{}, previousHash),
+		MerkleRoot:   merkleRoot(nil),
+		Hash:         calculateHash(len(Blockchain), timestamp, clock, merkleRoot(nil), previousHash),
 		Version:      version,
 		ShardID:      shardID,
 	}
 
 	Blockchain = append(Blockchain, newBlock)
+	ledgerStore.Changed()
 	log.Printf("✅ Block added to blockchain (Shard %d): %+v", shardID, newBlock)
+	return &Blockchain[len(Blockchain)-1]
+}
+
+// Transaction being assembled from its segments
+type segmentAssembly struct {
+	Segments []TransactionSegment
+	Client   string // IP the first segment came from
+	Started  time.Time
+}
+
+// Drop assemblies older than segmentAssemblyTTL and count the rest by
+// client. Caller must hold transactionMu.
+func sweepSegmentAssemblies(now time.Time) map[string]int {
+	perClient := make(map[string]int)
+	for txID, assembly := range transactionSegments {
+		if now.Sub(assembly.Started) > segmentAssemblyTTL {
+			delete(transactionSegments, txID)
+			log.Printf("⌛ Dropped partial transaction %s: %d of %d segments after %v",
+				txID, len(assembly.Segments), assembly.Segments[0].TotalSegments, segmentAssemblyTTL)
+			continue
+		}
+		perClient[assembly.Client]++
+	}
+	return perClient
 }
 
 func addTransactionSegmentHandler(c *gin.Context) {
+	if rejectUnsignable(c) {
+		return
+	}
 	var segment TransactionSegment
 
 	if err := c.ShouldBindJSON(&segment); err != nil {
@@ -153,25 +216,85 @@ func addTransactionSegmentHandler(c *gin.Context) {
 		return
 	}
 
+	if segment.TransactionID == "" || segment.TotalSegments < 1 || segment.SegmentIndex < 0 || segment.SegmentIndex >= segment.TotalSegments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment index or count"})
+		return
+	}
+	// Every segment carries at least one byte of a budgeted payload
+	if txBudget.MaxPayloadBytes > 0 && segment.TotalSegments > txBudget.MaxPayloadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Too many segments for the transaction budget", "status": statusBudgetExceeded})
+		return
+	}
+
 	transactionMu.Lock()
-	transactionSegments[segment.TransactionID] = append(transactionSegments[segment.TransactionID], segment)
+	now, client := time.Now(), c.ClientIP()
+	perClient := sweepSegmentAssemblies(now)
+	assembly := transactionSegments[segment.TransactionID]
+	if assembly == nil {
+		if len(transactionSegments) >= maxPendingSegmentTxs {
+			transactionMu.Unlock()
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many transactions are being assembled"})
+			return
+		}
+		if perClient[client] >= maxPendingSegmentTxsPerClient {
+			transactionMu.Unlock()
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many transactions are being assembled for this client"})
+			return
+		}
+		assembly = &segmentAssembly{Client: client, Started: now}
+	}
+	received := assembly.Segments
+	size := len(segment.Data)
+	for _, seg := range received {
+		if seg.TotalSegments != segment.TotalSegments {
+			transactionMu.Unlock()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Segment count differs from earlier segments"})
+			return
+		}
+		if seg.SegmentIndex == segment.SegmentIndex {
+			transactionMu.Unlock()
+			c.JSON(http.StatusOK, gin.H{"message": "Segment already received"})
+			return
+		}
+		size += len(seg.Data)
+	}
+	// Drop the whole transaction once its payload is over budget
+	if err := checkTxBudget(ResourceUsage{PayloadBytes: size}); err != nil {
+		delete(transactionSegments, segment.TransactionID)
+		transactionMu.Unlock()
+		TransactionMu.Lock()
+		transactionStatus[segment.TransactionID] = statusBudgetExceeded
+		TransactionMu.Unlock()
+		log.Printf("❌ Transaction %s dropped while assembling: %v", segment.TransactionID, err)
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "status": statusBudgetExceeded})
+		return
+	}
+	received = append(received, segment)
+	complete := len(received) == segment.TotalSegments
+	if complete {
+		delete(transactionSegments, segment.TransactionID)
+	} else {
+		assembly.Segments = received
+		transactionSegments[segment.TransactionID] = assembly
+	}
 	transactionMu.Unlock()
 
 	// Check if all segments have arrived
-	if len(transactionSegments[segment.TransactionID]) == segment.TotalSegments {
+	if complete {
+		// A completed transaction sent again must not be appended twice
+		if status, ok := claimTransaction(segment.TransactionID); !ok {
+			rejectDuplicate(c, segment.TransactionID, status)
+			return
+		}
+
 		// Reconstruct full transaction data
+		sort.Slice(received, func(i, j int) bool { return received[i].SegmentIndex < received[j].SegmentIndex })
 		fullData := ""
-		for _, seg := range transactionSegments[segment.TransactionID] {
+		for _, seg := range received {
 			fullData += seg.Data
 		}
 
-		// Ensure blockchain has at least one block
-		if len(Blockchain) == 0 {
-			log.Println("⚠️ Blockchain is empty, initializing genesis block.")
-			addBlock("genesis")
-		}
-
-		// Append transaction to the latest block
+		// Append transaction to the open block
 		newTransaction := Transaction{
 			ContainerID:   segment.TransactionID,
 			Timestamp:     time.Now().Format(time.RFC3339),
@@ -179,18 +302,17 @@ func addTransactionSegmentHandler(c *gin.Context) {
 			Version:       len(Blockchain),
 			Data:          fullData,
 			Status:        "completed",
+			Usage:         ResourceUsage{PayloadBytes: len(fullData)},
 		}
 
-		BlockchainMu.Lock()
-		Blockchain[len(Blockchain)-1].Transactions = append(Blockchain[len(Blockchain)-1].Transactions, newTransaction)
-		BlockchainMu.Unlock()
+		blockProducer.Add(newTransaction)
+		TransactionMu.Lock()
+		transactionStatus[segment.TransactionID] = "completed"
+		TransactionMu.Unlock()
 
 		// Log transaction completion
 		log.Printf("✅ Transaction fully assembled: %s", fullData)
 
-		// Remove stored transaction segments after successful assembly
-		delete(transactionSegments, segment.TransactionID)
-
 		c.JSON(http.StatusOK, gin.H{"message": "Transaction successfully completed"})
 		return
 	}
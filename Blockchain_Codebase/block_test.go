@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSegmentAssembliesExpireAndAreCappedPerClient(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/segment", addTransactionSegmentHandler)
+	transactionMu.Lock()
+	transactionSegments = make(map[string]*segmentAssembly)
+	transactionMu.Unlock()
+
+	send := func(txID, remoteAddr string) int {
+		body, _ := json.Marshal(TransactionSegment{TransactionID: txID, SegmentIndex: 0, TotalSegments: 2, Data: "x"})
+		req := httptest.NewRequest(http.MethodPost, "/segment", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < maxPendingSegmentTxsPerClient; i++ {
+		if code := send(fmt.Sprintf("tx-seg-%d", i), "192.0.2.1:1000"); code != http.StatusOK {
+			t.Fatalf("segment %d = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if code := send("tx-seg-over", "192.0.2.1:1000"); code != http.StatusTooManyRequests {
+		t.Fatalf("assembly over the per-client cap = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := send("tx-seg-other", "192.0.2.2:1000"); code != http.StatusOK {
+		t.Fatalf("another client's assembly = %d, want %d", code, http.StatusOK)
+	}
+
+	// Age the first client's assemblies past the TTL
+	transactionMu.Lock()
+	for _, assembly := range transactionSegments {
+		if assembly.Client == "192.0.2.1" {
+			assembly.Started = time.Now().Add(-2 * segmentAssemblyTTL)
+		}
+	}
+	transactionMu.Unlock()
+	if code := send("tx-seg-over", "192.0.2.1:1000"); code != http.StatusOK {
+		t.Fatalf("assembly after the old ones expired = %d, want %d", code, http.StatusOK)
+	}
+	transactionMu.Lock()
+	defer transactionMu.Unlock()
+	if n := len(transactionSegments); n != 2 {
+		t.Errorf("%d assemblies pending, want 2", n)
+	}
+}
+
+func TestCompletedSegmentedTransactionIsNotAppendedTwice(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/segment", addTransactionSegmentHandler)
+	setupRollbackChain(t, 1)
+
+	send := func() int {
+		body, _ := json.Marshal(TransactionSegment{TransactionID: "tx-seg-once", SegmentIndex: 0, TotalSegments: 1, Data: "x"})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/segment", bytes.NewReader(body)))
+		return w.Code
+	}
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("first submission = %d, want %d", code, http.StatusOK)
+	}
+	if code := send(); code != http.StatusConflict {
+		t.Fatalf("resubmission = %d, want %d", code, http.StatusConflict)
+	}
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	appended := 0
+	for _, block := range Blockchain {
+		for _, tx := range block.Transactions {
+			if tx.TransactionID == "tx-seg-once" {
+				appended++
+			}
+		}
+	}
+	if appended != 1 {
+		t.Errorf("transaction appended %d times, want 1", appended)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Execution budgets. Every transaction is charged for its payload bytes,
+// the world state keys and ops it touches, and its execution time. A
+// transaction over the per-transaction budget, or too big to fit even an
+// empty block, fails with status budget-exceeded before it commits. Blocks
+// are sealed early rather than grow past the per-block budget.
+
+// Resources a transaction or block used
+type ResourceUsage struct {
+	PayloadBytes int     `json:"payload_bytes"`
+	Ops          int     `json:"ops"`     // Reads, writes and commutative ops
+	ExecTime     float64 `json:"exec_ms"` // Milliseconds
+}
+
+func (u ResourceUsage) plus(other ResourceUsage) ResourceUsage {
+	return ResourceUsage{
+		PayloadBytes: u.PayloadBytes + other.PayloadBytes,
+		Ops:          u.Ops + other.Ops,
+		ExecTime:     u.ExecTime + other.ExecTime,
+	}
+}
+
+// Limits on resource usage; 0 means no limit
+type Budget struct {
+	MaxPayloadBytes int
+	MaxOps          int
+	MaxExecTime     time.Duration
+}
+
+var (
+	txBudget    = Budget{MaxPayloadBytes: 4 << 10, MaxOps: 16, MaxExecTime: 15 * time.Second}
+	blockBudget = Budget{MaxPayloadBytes: 32 << 10, MaxOps: 256, MaxExecTime: time.Minute}
+)
+
+// Transactions being assembled from segments at once, in total and per
+// client
+const (
+	maxPendingSegmentTxs          = 1024
+	maxPendingSegmentTxsPerClient = 64
+)
+
+var segmentAssemblyTTL = 2 * time.Minute // Partial assemblies older than this are dropped
+
+// Status and log outcome of a transaction stopped by a budget
+const statusBudgetExceeded = "budget-exceeded"
+
+// Returned when usage is over a budget
+type BudgetError struct {
+	Scope    string // "transaction" or "block"
+	Resource string
+	Used     string
+	Limit    string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s budget exceeded: %s %s over limit %s", e.Scope, e.Resource, e.Used, e.Limit)
+}
+
+// First resource of u over the budget, or nil
+func (b Budget) check(scope string, u ResourceUsage) *BudgetError {
+	switch {
+	case b.MaxPayloadBytes > 0 && u.PayloadBytes > b.MaxPayloadBytes:
+		return &BudgetError{Scope: scope, Resource: "payload bytes", Used: fmt.Sprint(u.PayloadBytes), Limit: fmt.Sprint(b.MaxPayloadBytes)}
+	case b.MaxOps > 0 && u.Ops > b.MaxOps:
+		return &BudgetError{Scope: scope, Resource: "ops", Used: fmt.Sprint(u.Ops), Limit: fmt.Sprint(b.MaxOps)}
+	case b.MaxExecTime > 0 && u.ExecTime > float64(b.MaxExecTime.Milliseconds()):
+		return &BudgetError{Scope: scope, Resource: "execution time", Used: fmt.Sprintf("%.0fms", u.ExecTime), Limit: b.MaxExecTime.String()}
+	}
+	return nil
+}
+
+// Check a transaction's usage against its own budget and against an empty
+// block's
+func checkTxBudget(u ResourceUsage) error {
+	if err := txBudget.check("transaction", u); err != nil {
+		return err
+	}
+	if err := blockBudget.check("block", u); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Milliseconds since start
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
+
+// Reject a submission whose payload alone is over budget
+func rejectOverBudget(c *gin.Context, data string) bool {
+	if err := checkTxBudget(ResourceUsage{PayloadBytes: len(data)}); err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "status": statusBudgetExceeded})
+		return true
+	}
+	return false
+}
+
+func (b Budget) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"max_payload_bytes": b.MaxPayloadBytes,
+		"max_ops":           b.MaxOps,
+		"max_exec_time":     b.MaxExecTime.String(),
+	})
+}
+
+// API listing the configured budgets
+func getBudgets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"transaction": txBudget, "block": blockBudget})
+}
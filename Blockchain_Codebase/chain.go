@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Transactions are appended to the open block at the tip of the chain, so its
+// hash is only meaningful once it has been sealed again. Every append goes
+// through the block producer, which re-seals the open block; sealFrom also
+// re-links any blocks after it. verifyChain recomputes all of it from scratch.
+
+// Hash of a block's header
+func blockHash(b *Block) string {
+	return calculateHash(b.Index, b.Timestamp, b.HLC, b.MerkleRoot, b.PreviousHash)
+}
+
+// Re-seal the block at position pos and every block after it, so each
+// PreviousHash points at the new hash of its predecessor. Caller must hold
+// BlockchainMu.
+func sealFrom(pos int) {
+	for i := pos; i < len(Blockchain); i++ {
+		if i > 0 {
+			Blockchain[i].PreviousHash = Blockchain[i-1].Hash
+		}
+		Blockchain[i].MerkleRoot = merkleRoot(Blockchain[i].Transactions)
+		Blockchain[i].Hash = blockHash(&Blockchain[i])
+	}
+}
+
+// One problem found while verifying the chain
+type BlockIssue struct {
+	Index    int    `json:"index"`
+	Problem  string `json:"problem"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// Transaction whose ledger entry disagrees with the rest of the node
+type TxMismatch struct {
+	TransactionID string `json:"transaction_id"`
+	Block         int    `json:"block"`
+	Problem       string `json:"problem"`
+}
+
+// Inconsistency between a shard's view and the chain
+type ShardIssue struct {
+	ShardID int    `json:"shard_id"`
+	Block   int    `json:"block"`
+	Problem string `json:"problem"`
+}
+
+// Result of verifying the chain
+type ChainReport struct {
+	Valid        bool         `json:"valid"`
+	Blocks       int          `json:"blocks"`
+	FirstBroken  *BlockIssue  `json:"first_broken,omitempty"`
+	BlockIssues  []BlockIssue `json:"block_issues"`
+	Transactions []TxMismatch `json:"mismatched_transactions"`
+	Shards       []ShardIssue `json:"shard_issues"`
+}
+
+// Cross-checks available to a running node; the CLI only has the blocks
+type verifyContext struct {
+	shardViews map[int][]Block           // Blocks each shard holds
+	logs       map[string]TransactionLog // Latest log entry per transaction
+	statuses   map[string]string
+	genesis    string // Expected genesis hash
+}
+
+// Recompute every block hash and PreviousHash link, and cross-check the
+// transactions and shard assignments against whatever context is given
+func verifyChain(blocks []Block, ctx verifyContext) ChainReport {
+	report := ChainReport{
+		Blocks:       len(blocks),
+		BlockIssues:  make([]BlockIssue, 0),
+		Transactions: make([]TxMismatch, 0),
+		Shards:       make([]ShardIssue, 0),
+	}
+
+	seen := make(map[string]int)
+	for i := range blocks {
+		b := &blocks[i]
+		expectedPrev := "0" // Genesis
+		if i > 0 {
+			expectedPrev = blocks[i-1].Hash
+		}
+		if b.PreviousHash != expectedPrev {
+			report.BlockIssues = append(report.BlockIssues, BlockIssue{Index: b.Index, Problem: "previous hash does not match predecessor", Expected: expectedPrev, Actual: b.PreviousHash})
+		}
+		if computed := merkleRoot(b.Transactions); computed != b.MerkleRoot {
+			report.BlockIssues = append(report.BlockIssues, BlockIssue{Index: b.Index, Problem: "merkle root does not match transactions", Expected: computed, Actual: b.MerkleRoot})
+		}
+		if computed := blockHash(b); computed != b.Hash {
+			report.BlockIssues = append(report.BlockIssues, BlockIssue{Index: b.Index, Problem: "stored hash does not match header", Expected: computed, Actual: b.Hash})
+		}
+		if i == 0 && ctx.genesis != "" && b.Hash != ctx.genesis {
+			report.BlockIssues = append(report.BlockIssues, BlockIssue{Index: b.Index, Problem: "genesis block does not match the genesis file", Expected: ctx.genesis, Actual: b.Hash})
+		}
+		if !b.Sealed && i < len(blocks)-1 {
+			report.BlockIssues = append(report.BlockIssues, BlockIssue{Index: b.Index, Problem: "unsealed block before the tip"})
+		}
+		if b.ShardID < 0 {
+			report.Shards = append(report.Shards, ShardIssue{ShardID: b.ShardID, Block: b.Index, Problem: "negative shard ID"})
+		}
+
+		for _, tx := range b.Transactions {
+			if prev, dup := seen[tx.TransactionID]; dup {
+				report.Transactions = append(report.Transactions, TxMismatch{TransactionID: tx.TransactionID, Block: b.Index, Problem: fmt.Sprintf("duplicate of transaction in block %d", prev)})
+				continue
+			}
+			seen[tx.TransactionID] = b.Index
+			if contentTxIDPattern.MatchString(tx.TransactionID) && contentTxID(tx.Source, tx.Target, tx.Data, tx.TxOrigin) != tx.TransactionID {
+				report.Transactions = append(report.Transactions, TxMismatch{TransactionID: tx.TransactionID, Block: b.Index, Problem: "transaction ID does not match its content"})
+			}
+			if entry, ok := ctx.logs[tx.TransactionID]; ok && (entry.Source != tx.Source || entry.Target != tx.Target || entry.Message != tx.Data) {
+				report.Transactions = append(report.Transactions, TxMismatch{TransactionID: tx.TransactionID, Block: b.Index, Problem: "differs from transaction log"})
+			}
+			if status, ok := ctx.statuses[tx.TransactionID]; ok && status != "completed" {
+				report.Transactions = append(report.Transactions, TxMismatch{TransactionID: tx.TransactionID, Block: b.Index, Problem: fmt.Sprintf("in ledger but status is %s", status)})
+			}
+		}
+	}
+
+	byIndex := make(map[int]*Block, len(blocks))
+	for i := range blocks {
+		byIndex[blocks[i].Index] = &blocks[i]
+	}
+	for shardID, view := range ctx.shardViews {
+		for _, held := range view {
+			b, ok := byIndex[held.Index]
+			switch {
+			case !ok:
+				report.Shards = append(report.Shards, ShardIssue{ShardID: shardID, Block: held.Index, Problem: "block is not in the chain"})
+			case b.ShardID != shardID:
+				report.Shards = append(report.Shards, ShardIssue{ShardID: shardID, Block: held.Index, Problem: fmt.Sprintf("block belongs to shard %d", b.ShardID)})
+			case b.Hash != held.Hash:
+				report.Shards = append(report.Shards, ShardIssue{ShardID: shardID, Block: held.Index, Problem: "shard holds a stale copy of the block"})
+			}
+		}
+	}
+
+	if len(report.BlockIssues) > 0 {
+		report.FirstBroken = &report.BlockIssues[0]
+	}
+	report.Valid = len(report.BlockIssues) == 0 && len(report.Transactions) == 0 && len(report.Shards) == 0
+	return report
+}
+
+// First problem found, or the number of issues if no block is broken
+func (r ChainReport) summary() string {
+	if r.FirstBroken != nil {
+		return fmt.Sprintf("block %d: %s", r.FirstBroken.Index, r.FirstBroken.Problem)
+	}
+	if len(r.Transactions) > 0 {
+		return fmt.Sprintf("transaction %s: %s", r.Transactions[0].TransactionID, r.Transactions[0].Problem)
+	}
+	return fmt.Sprintf("%d block, %d transaction, %d shard issues", len(r.BlockIssues), len(r.Transactions), len(r.Shards))
+}
+
+// Snapshot the running node and verify it
+func verifyNode() ChainReport {
+	ctx := verifyContext{
+		shardViews: make(map[int][]Block),
+		logs:       make(map[string]TransactionLog),
+		statuses:   make(map[string]string),
+		genesis:    genesisHash,
+	}
+
+	transactionLogsMu.Lock()
+	for _, entry := range transactionLogs {
+		ctx.logs[entry.TxID] = entry
+	}
+	transactionLogsMu.Unlock()
+
+	TransactionMu.Lock()
+	for txID, status := range transactionStatus {
+		ctx.statuses[txID] = status
+	}
+	TransactionMu.Unlock()
+
+	BlockchainMu.Lock()
+	blocks := make([]Block, len(Blockchain))
+	copy(blocks, Blockchain)
+	for i := range shards {
+		shards[i].mu.Lock()
+		for _, b := range shards[i].Blocks {
+			ctx.shardViews[shards[i].ID] = append(ctx.shardViews[shards[i].ID], *b)
+		}
+		shards[i].mu.Unlock()
+	}
+	BlockchainMu.Unlock()
+
+	report := verifyChain(blocks, ctx)
+	for _, chain := range allShardChains() {
+		report.Shards = append(report.Shards, chain.verify()...)
+	}
+	report.Shards = append(report.Shards, beaconChain.verify()...)
+	report.Valid = report.Valid && len(report.Shards) == 0
+	return report
+}
+
+// API to verify the integrity of the chain
+func verifyChainHandler(c *gin.Context) {
+	report := verifyNode()
+	if !report.Valid {
+		log.Printf("⚠️ Chain verification failed: %d block, %d transaction, %d shard issues",
+			len(report.BlockIssues), len(report.Transactions), len(report.Shards))
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// `verify [file]`: verify a ledger file, by default blockchainFile, or a
+// chain exported from GET /blockchain. Returns the process exit code.
+func runVerifyCommand(args []string) int {
+	path := blockchainFile
+	if len(args) > 0 {
+		path = args[0]
+	}
+	snapshot, err := readLedgerFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 2
+	}
+
+	report := verifyChain(snapshot.Blocks, verifyContext{statuses: snapshot.Statuses, genesis: genesisHash})
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Valid {
+		return 1
+	}
+	fmt.Printf("✅ %d blocks verified\n", report.Blocks)
+	return 0
+}
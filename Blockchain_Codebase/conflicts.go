@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Kind of concurrency conflict
+type ConflictKind string
+
+const (
+	ConflictWriteWrite      ConflictKind = "write-write"
+	ConflictReadWrite       ConflictKind = "read-write"
+	ConflictDeadlock        ConflictKind = "deadlock"
+	ConflictCrossShardAbort ConflictKind = "cross-shard-abort"
+)
+
+// Structured record of a detected conflict and how it was resolved
+type ConflictRecord struct {
+	ID         int          `json:"id"`
+	Kind       ConflictKind `json:"kind"`
+	TxIDs      []string     `json:"tx_ids"`
+	Keys       []string     `json:"keys,omitempty"`
+	Blocks     []int        `json:"blocks,omitempty"`
+	Shards     []int        `json:"shards"`
+	Resolution string       `json:"resolution"`
+	Policy     string       `json:"policy,omitempty"` // Policy that decided the resolution
+	Detail     string       `json:"detail,omitempty"`
+	DetectedAt time.Time    `json:"detected_at"`
+	ResolvedAt time.Time    `json:"resolved_at"`
+	HLC        HLC          `json:"hlc"` // Hybrid logical clock when recorded
+}
+
+const (
+	defaultConflictPageSize = 50
+	maxConflictPageSize     = 500
+)
+
+// Conflicts waiting to be written to the store. Conflicts are often
+// recorded with the lock manager's lock held, so the store is written from
+// the background rather than by recordConflict.
+type ConflictWriter struct {
+	mu      sync.Mutex
+	pending []ConflictRecord
+	wake    chan struct{}
+}
+
+var conflictWriter = &ConflictWriter{wake: make(chan struct{}, 1)}
+
+// Queue a record for the store. Never blocks.
+func (w *ConflictWriter) Enqueue(record ConflictRecord) {
+	w.mu.Lock()
+	w.pending = append(w.pending, record)
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Write queued records to the store, oldest first
+func (w *ConflictWriter) Run() {
+	for range w.wake {
+		w.Flush()
+	}
+}
+
+// Write every queued record now
+func (w *ConflictWriter) Flush() {
+	w.mu.Lock()
+	batch := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+	for _, record := range batch {
+		if err := store.SaveConflict(record); err != nil {
+			log.Printf("❌ Failed to write conflict %d to the %s store: %v", record.ID, store.Name(), err)
+		}
+	}
+}
+
+// Record a concurrency conflict for the /conflicts feed. Missing shards are
+// derived from the blocks involved and missing timestamps default to now.
+// Must not be called while holding BlockchainMu.
+func recordConflict(record ConflictRecord) {
+	now := time.Now()
+	if record.DetectedAt.IsZero() {
+		record.DetectedAt = now
+	}
+	if record.ResolvedAt.IsZero() {
+		record.ResolvedAt = now
+	}
+	if record.Shards == nil {
+		record.Shards = shardsForBlocks(record.Blocks)
+	}
+
+	conflictsMu.Lock()
+	record.ID = 1
+	if n := len(concurrencyConflicts); n > 0 {
+		record.ID = concurrencyConflicts[n-1].ID + 1 // Records restored at startup may have gaps
+	}
+	record.HLC = hybridClock.Now() // Under the lock, so IDs and clocks agree
+	concurrencyConflicts = append(concurrencyConflicts, record)
+	conflictsMu.Unlock()
+	conflictWriter.Enqueue(record)
+	log.Printf("⚔️ Conflict recorded: [%s] %v → %s", record.Kind, record.TxIDs, record.Resolution)
+}
+
+// Distinct, sorted shard IDs of the given blocks
+func shardsForBlocks(blocks []int) []int {
+	seen := make(map[int]bool)
+	shardIDs := make([]int, 0, len(blocks))
+
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	for _, index := range blocks {
+		shardID := 0
+		if block := findBlockByIndex(index); block != nil {
+			shardID = block.ShardID
+		} else {
+			shardID = index % NumShards
+		}
+		if !seen[shardID] {
+			seen[shardID] = true
+			shardIDs = append(shardIDs, shardID)
+		}
+	}
+	sort.Ints(shardIDs)
+	return shardIDs
+}
+
+// A stale key we also wrote is a write-write conflict, otherwise read-write
+func staleReadKind(stale *StaleReadError, writes []WriteEntry) ConflictKind {
+	for _, w := range writes {
+		if w.Key == stale.Key {
+			return ConflictWriteWrite
+		}
+	}
+	return ConflictReadWrite
+}
+
+// Filters accepted by GET /conflicts
+type conflictQuery struct {
+	shard  *int
+	kind   ConflictKind
+	policy string
+	since  time.Time
+	until  time.Time
+	offset int
+	limit  int
+}
+
+func parseConflictQuery(c *gin.Context) (conflictQuery, error) {
+	q := conflictQuery{limit: defaultConflictPageSize}
+
+	if v := c.Query("shard"); v != "" {
+		shard, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid shard %q", v)
+		}
+		q.shard = &shard
+	}
+	if v := c.Query("kind"); v != "" {
+		switch kind := ConflictKind(v); kind {
+		case ConflictWriteWrite, ConflictReadWrite, ConflictDeadlock, ConflictCrossShardAbort:
+			q.kind = kind
+		default:
+			return q, fmt.Errorf("invalid kind %q", v)
+		}
+	}
+	q.policy = c.Query("policy")
+	for name, dst := range map[string]*time.Time{"since": &q.since, "until": &q.until} {
+		if v := c.Query(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s %q (want RFC3339)", name, v)
+			}
+			*dst = t
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", v)
+		}
+		q.offset = offset
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.limit = min(limit, maxConflictPageSize)
+	}
+	return q, nil
+}
+
+func (q conflictQuery) matches(record ConflictRecord) bool {
+	if q.kind != "" && record.Kind != q.kind {
+		return false
+	}
+	if q.policy != "" && record.Policy != q.policy {
+		return false
+	}
+	if q.shard != nil {
+		found := false
+		for _, shardID := range record.Shards {
+			if shardID == *q.shard {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.since.IsZero() && record.DetectedAt.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && record.DetectedAt.After(q.until) {
+		return false
+	}
+	return true
+}
+
+// Fetch concurrency conflicts, filtered by ?shard=, ?kind=, ?policy=,
+// ?since=, ?until= and paginated with ?offset= and ?limit=
+func getConflicts(c *gin.Context) {
+	q, err := parseConflictQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conflictsMu.Lock()
+	matched := make([]ConflictRecord, 0)
+	for _, record := range concurrencyConflicts {
+		if q.matches(record) {
+			matched = append(matched, record)
+		}
+	}
+	total := len(concurrencyConflicts)
+	conflictsMu.Unlock()
+
+	page := matched[min(q.offset, len(matched)):min(q.offset+q.limit, len(matched))]
+
+	log.Printf("🔍 Fetching concurrency conflicts. Matched: %d of %d", len(matched), total)
+	c.JSON(http.StatusOK, gin.H{
+		"total_conflicts":   total,
+		"matched":           len(matched),
+		"offset":            q.offset,
+		"limit":             q.limit,
+		"conflicts":         page,
+		"avoided_conflicts": avoidedConflicts.Snapshot(),
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestRecordConflictWritesStoreInBackground(t *testing.T) {
+	conflictWriter.Flush() // Conflicts of earlier tests go to the old store
+	saved := store
+	t.Cleanup(func() { store = saved })
+	store = NewMemoryStore()
+
+	recordConflict(ConflictRecord{Kind: ConflictDeadlock, TxIDs: []string{"tx-a", "tx-b"}, Shards: []int{0}, Resolution: "aborted tx-b"})
+	if stored, _ := store.LoadConflicts(); len(stored) != 0 {
+		t.Fatalf("recordConflict wrote %d records to the store itself", len(stored))
+	}
+	conflictWriter.Flush()
+	stored, err := store.LoadConflicts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Kind != ConflictDeadlock {
+		t.Fatalf("store holds %+v after the flush, want the deadlock", stored)
+	}
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Two-phase commit for transactions whose keys live on more than one shard.
+// The coordinator asks every participating Shard to prepare its part of the
+// read/write sets (validate and lock the keys), then commits on all of them
+// if every vote was yes and aborts everywhere otherwise. Each step is
+// appended to a durable coordinator log so in-doubt transactions can be
+// resolved after a crash or a timeout (presumed abort).
+
+const coordinatorLogFile = "coordinator.log"
+
+// Coordinator log states
+const (
+	twoPCBegin  = "begin"
+	twoPCCommit = "commit"
+	twoPCAbort  = "abort"
+	twoPCEnd    = "end"
+)
+
+// How long a participant may stay prepared before the transaction is
+// considered in doubt and resolved from the coordinator log
+var twoPCTimeout = 5 * time.Second
+
+// One line of the coordinator log
+type coordRecord struct {
+	TxID         string               `json:"tx_id"`
+	State        string               `json:"state"`
+	Participants []int                `json:"participants,omitempty"`
+	Writes       map[int][]WriteEntry `json:"writes,omitempty"`
+	Height       int                  `json:"height,omitempty"` // World state height of the commit, on end records
+	Time         time.Time            `json:"time"`
+}
+
+// Durable, append-only coordinator log
+type CoordinatorLog struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	decided map[string]string // Commit or abort per transaction, loaded on first use
+}
+
+var coordinatorLog = &CoordinatorLog{path: coordinatorLogFile}
+
+// Append a record and fsync it before returning
+func (cl *CoordinatorLog) Append(record coordRecord) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.append(record)
+}
+
+// Caller must hold cl.mu
+func (cl *CoordinatorLog) append(record coordRecord) error {
+	if cl.file == nil {
+		f, err := os.OpenFile(cl.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		cl.file = f
+	}
+	record.Time = time.Now()
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := cl.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := cl.file.Sync(); err != nil {
+		return err
+	}
+	if cl.decided != nil {
+		switch record.State {
+		case twoPCCommit, twoPCAbort:
+			cl.decided[record.TxID] = record.State
+		}
+	}
+	return nil
+}
+
+// Log a decision unless the transaction already has one, and return the
+// decision that stands. The coordinator and the in-doubt resolver both
+// decide through here, so one cannot presume abort while the other commits.
+func (cl *CoordinatorLog) Decide(txID, state string) (string, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if err := cl.loadDecisions(); err != nil {
+		return "", err
+	}
+	if decided, ok := cl.decided[txID]; ok {
+		return decided, nil
+	}
+	if err := cl.append(coordRecord{TxID: txID, State: state}); err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+// Caller must hold cl.mu
+func (cl *CoordinatorLog) loadDecisions() error {
+	if cl.decided != nil {
+		return nil
+	}
+	records, err := cl.load()
+	if err != nil {
+		return err
+	}
+	cl.decided = make(map[string]string)
+	for _, record := range records {
+		switch record.State {
+		case twoPCCommit, twoPCEnd: // Only committed transactions are ended after a decision
+			cl.decided[record.TxID] = twoPCCommit
+		case twoPCAbort:
+			cl.decided[record.TxID] = twoPCAbort
+		}
+	}
+	return nil
+}
+
+// Latest record per transaction, in log order
+func (cl *CoordinatorLog) Load() ([]coordRecord, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.load()
+}
+
+// Caller must hold cl.mu
+func (cl *CoordinatorLog) load() ([]coordRecord, error) {
+	f, err := os.Open(cl.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	latest := make(map[string]int)
+	var records []coordRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record coordRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("⚠️ Skipping corrupt coordinator log line: %v", err)
+			continue // A torn final write after a crash
+		}
+		// Keep the participants and writes from earlier records
+		if i, ok := latest[record.TxID]; ok {
+			prev := records[i]
+			if record.Participants == nil {
+				record.Participants = prev.Participants
+			}
+			if record.Writes == nil {
+				record.Writes = prev.Writes
+			}
+			records[i] = record
+			continue
+		}
+		latest[record.TxID] = len(records)
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// Participant-side record of a transaction that voted yes
+type preparedTx struct {
+	meta       CommitMeta
+	writes     []WriteEntry
+	preparedAt time.Time
+}
+
+// Vote on the shard's part of a cross-shard transaction
+func (s *Shard) Prepare(meta CommitMeta, reads []ReadEntry, writes []WriteEntry) error {
+	s.mu.Lock()
+	overrides, err := worldState.PrepareKeys(meta, reads, writes)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.prepared == nil {
+		s.prepared = make(map[string]preparedTx)
+	}
+	s.prepared[meta.TxID] = preparedTx{meta: meta, writes: writes, preparedAt: time.Now()}
+	s.mu.Unlock()
+
+	// Recording takes BlockchainMu, which is ordered before s.mu
+	recordOverrides(meta, overrides, writes)
+	return nil
+}
+
+// Apply a prepared transaction. Idempotent: committing twice is a no-op.
+func (s *Shard) Commit(txID string) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.prepared[txID]
+	if !ok {
+		return 0, false
+	}
+	delete(s.prepared, txID)
+	return worldState.CommitPrepared(p.meta, p.writes), true
+}
+
+// Forget a prepared transaction and release its keys
+func (s *Shard) Abort(txID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.prepared, txID)
+	worldState.ReleaseLocks(txID)
+}
+
+// Prepared transactions older than the timeout
+func (s *Shard) inDoubt(timeout time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var txIDs []string
+	for txID, p := range s.prepared {
+		if time.Since(p.preparedAt) > timeout {
+			txIDs = append(txIDs, txID)
+		}
+	}
+	return txIDs
+}
+
+// Shard that owns a block's keys. Shard IDs created at runtime beyond
+// NumShards are folded back onto the fixed participants.
+func participantForBlock(index int) int {
+	shardIDs := shardsForBlocks([]int{index})
+	return shardIDs[0] % NumShards
+}
+
+// Block an account key belongs to
+func blockForKey(key string) int {
+	index, _ := strconv.Atoi(strings.TrimPrefix(key, "acct-"))
+	return index
+}
+
+// Whether a transfer between the two blocks spans more than one shard
+func isCrossShard(source, target int) bool {
+	return participantForBlock(source) != participantForBlock(target)
+}
+
+// Run two-phase commit for the read/write sets across the owning shards.
+// Returns the highest commit height among the participants.
+func twoPhaseCommit(meta CommitMeta, reads []ReadEntry, writes []WriteEntry) (int, error) {
+	txID := meta.TxID
+	shardReads := make(map[int][]ReadEntry)
+	shardWrites := make(map[int][]WriteEntry)
+	for _, r := range reads {
+		shardID := participantForBlock(blockForKey(r.Key))
+		shardReads[shardID] = append(shardReads[shardID], r)
+	}
+	for _, w := range writes {
+		shardID := participantForBlock(blockForKey(w.Key))
+		shardWrites[shardID] = append(shardWrites[shardID], w)
+	}
+	participants := make([]int, 0, len(shardWrites))
+	for shardID := range shardReads {
+		participants = append(participants, shardID)
+	}
+	for shardID := range shardWrites {
+		if _, ok := shardReads[shardID]; !ok {
+			participants = append(participants, shardID)
+		}
+	}
+	sort.Ints(participants)
+
+	if err := coordinatorLog.Append(coordRecord{TxID: txID, State: twoPCBegin, Participants: participants, Writes: shardWrites}); err != nil {
+		return 0, fmt.Errorf("coordinator log: %w", err)
+	}
+
+	// Phase 1: collect votes in parallel, bounded by the timeout
+	type vote struct {
+		shardID int
+		err     error
+	}
+	votes := make(chan vote, len(participants))
+	for _, shardID := range participants {
+		go func(shardID int) {
+			votes <- vote{shardID, shards[shardID].Prepare(meta, shardReads[shardID], shardWrites[shardID])}
+		}(shardID)
+	}
+
+	var abortErr error
+	deadline := time.After(twoPCTimeout)
+	for received := 0; received < len(participants) && abortErr == nil; received++ {
+		select {
+		case v := <-votes:
+			if v.err != nil {
+				abortErr = fmt.Errorf("shard %d voted no: %w", v.shardID, v.err)
+			}
+		case <-deadline:
+			abortErr = fmt.Errorf("prepare timed out after %v", twoPCTimeout)
+		}
+	}
+
+	// Phase 2: the decision is durable once logged. The in-doubt resolver
+	// may have presumed abort for a slow participant first; its decision
+	// then stands for every shard.
+	if abortErr == nil {
+		decision, err := coordinatorLog.Decide(txID, twoPCCommit)
+		switch {
+		case err != nil:
+			abortErr = fmt.Errorf("coordinator log: %w", err)
+		case decision != twoPCCommit:
+			abortErr = errors.New("presumed aborted by the in-doubt resolver")
+		}
+	}
+	if abortErr != nil {
+		if _, err := coordinatorLog.Decide(txID, twoPCAbort); err != nil {
+			log.Printf("❌ Failed to log abort for %s: %v", txID, err)
+		}
+		// Late yes votes are rolled back by the in-doubt resolver
+		for _, shardID := range participants {
+			shards[shardID].Abort(txID)
+		}
+		recordConflict(ConflictRecord{
+			Kind:       ConflictCrossShardAbort,
+			TxIDs:      []string{txID},
+			Keys:       keysOf(writes),
+			Shards:     participants,
+			Resolution: "2PC aborted on all participants",
+			Policy:     string(CrossShard2PC),
+			Detail:     abortErr.Error(),
+		})
+		return 0, abortErr
+	}
+
+	height := 0
+	for _, shardID := range participants {
+		if h, ok := shards[shardID].Commit(txID); ok && h > height {
+			height = h
+		}
+	}
+	if err := coordinatorLog.Append(coordRecord{TxID: txID, State: twoPCEnd, Height: height}); err != nil {
+		log.Printf("❌ Failed to log end for %s: %v", txID, err)
+	}
+	log.Printf("🤝 2PC committed %s on shards %v", txID, participants)
+	return height, nil
+}
+
+func keysOf(writes []WriteEntry) []string {
+	keys := make([]string, 0, len(writes))
+	for _, w := range writes {
+		keys = append(keys, w.Key)
+	}
+	return keys
+}
+
+// Periodically resolve participants stuck in the prepared state by
+// consulting the coordinator log: commit if a commit was logged, otherwise
+// abort (presumed abort) and log the abort.
+func runInDoubtResolver() {
+	ticker := time.NewTicker(twoPCTimeout)
+	for range ticker.C {
+		for i := range shards {
+			for _, txID := range shards[i].inDoubt(twoPCTimeout) {
+				resolveInDoubt(&shards[i], txID)
+			}
+		}
+	}
+}
+
+// Presume abort unless the coordinator already decided; whichever decision
+// is logged first stands.
+func resolveInDoubt(shard *Shard, txID string) {
+	decision, err := coordinatorLog.Decide(txID, twoPCAbort)
+	switch {
+	case err != nil:
+		log.Printf("❌ Failed to log abort for %s, leaving it prepared: %v", txID, err)
+	case decision == twoPCCommit:
+		shard.Commit(txID)
+		log.Printf("🩹 In-doubt %s committed on shard %d", txID, shard.ID)
+	default:
+		shard.Abort(txID)
+		log.Printf("🩹 In-doubt %s aborted on shard %d", txID, shard.ID)
+	}
+}
+
+// After a restart, finish every transaction the coordinator log left open:
+// a logged commit is re-applied, anything undecided is aborted.
+func recoverCoordinator() {
+	records, err := coordinatorLog.Load()
+	if err != nil {
+		log.Printf("❌ Failed to read coordinator log: %v", err)
+		return
+	}
+
+	for _, record := range records {
+		switch record.State {
+		case twoPCCommit:
+			height := 0
+			for _, shardID := range record.Participants {
+				height = worldState.CommitPrepared(CommitMeta{TxID: record.TxID}, record.Writes[shardID])
+			}
+			if err := coordinatorLog.Append(coordRecord{TxID: record.TxID, State: twoPCEnd, Height: height}); err != nil {
+				log.Printf("❌ Failed to log end for %s: %v", record.TxID, err)
+			}
+			TransactionMu.Lock()
+			transactionStatus[record.TxID] = "completed"
+			TransactionMu.Unlock()
+			log.Printf("🩹 Recovered in-doubt %s: committed", record.TxID)
+		case twoPCBegin:
+			if _, err := coordinatorLog.Decide(record.TxID, twoPCAbort); err != nil {
+				log.Printf("❌ Failed to log abort for %s: %v", record.TxID, err)
+			}
+			TransactionMu.Lock()
+			transactionStatus[record.TxID] = "aborted"
+			TransactionMu.Unlock()
+			log.Printf("🩹 Recovered in-doubt %s: aborted", record.TxID)
+		}
+	}
+}
+
+// Finality and throughput of cross-shard transfers for one protocol
+type ModeStats struct {
+	Transactions int     `json:"transactions"`
+	Committed    int     `json:"committed"`
+	AvgExecTime  float64 `json:"avg_exec_time_ms"`
+	AvgFinality  float64 `json:"avg_finality_ms"`
+	AvgTPS       float64 `json:"avg_tps"`
+}
+
+// Aggregate cross-shard transfers per protocol (2pc / receipts)
+func summariseCrossShardModes(logs []TransactionLog) map[string]ModeStats {
+	stats := make(map[string]ModeStats)
+	for _, entry := range logs {
+		if entry.Mode == "" {
+			continue
+		}
+		s := stats[entry.Mode]
+		s.Transactions++
+		if entry.Outcome == OutcomeCommitted {
+			n := float64(s.Committed)
+			s.Committed++
+			s.AvgExecTime = (s.AvgExecTime*n + entry.ExecTime) / (n + 1)
+			s.AvgFinality = (s.AvgFinality*n + entry.Finality) / (n + 1)
+			s.AvgTPS = (s.AvgTPS*n + entry.TPS) / (n + 1)
+		}
+		stats[entry.Mode] = s
+	}
+	return stats
+}
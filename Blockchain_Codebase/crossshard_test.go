@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCoordinatorLogFirstDecisionStands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coordinator.log")
+	cl := &CoordinatorLog{path: path}
+	if err := cl.Append(coordRecord{TxID: "tx-1", State: twoPCBegin}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cl.Decide("tx-1", twoPCAbort); err != nil || got != twoPCAbort {
+		t.Fatalf("resolver decided %q (%v), want abort", got, err)
+	}
+	if got, _ := cl.Decide("tx-1", twoPCCommit); got != twoPCAbort {
+		t.Fatalf("coordinator committed over a presumed abort: %q", got)
+	}
+
+	// The decision survives a restart
+	reopened := &CoordinatorLog{path: path}
+	if got, _ := reopened.Decide("tx-1", twoPCCommit); got != twoPCAbort {
+		t.Fatalf("after restart the decision is %q, want abort", got)
+	}
+	if got, _ := reopened.Decide("tx-2", twoPCCommit); got != twoPCCommit {
+		t.Fatalf("undecided transaction got %q, want commit", got)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Protocol used for transfers whose blocks live on different shards
+type CrossShardMode string
+
+const (
+	CrossShard2PC      CrossShardMode = "2pc"
+	CrossShardReceipts CrossShardMode = "receipts"
+)
+
+func (m *CrossShardMode) String() string {
+	return string(*m)
+}
+
+// Set implements flag.Value
+func (m *CrossShardMode) Set(value string) error {
+	switch CrossShardMode(value) {
+	case CrossShard2PC, CrossShardReceipts:
+		*m = CrossShardMode(value)
+		return nil
+	}
+	return fmt.Errorf("unknown cross-shard mode %q (want 2pc or receipts)", value)
+}
+
+// Mode used when a request does not pick one
+var defaultCrossShardMode = CrossShard2PC
+
+// Per-run execution options chosen by the request or the server defaults
+type ExecOptions struct {
+	Priority       int
+	Commutative    bool   // Same-shard transfers use commutative ops
+	Floor          *int64 // Lowest balance a commutative debit may leave
+	DeadlockPolicy DeadlockPolicy
+	CrossShardMode CrossShardMode
+	ConflictPolicy ConflictPolicy
+	Signer         SignedRequest // Verified signature of the submitter, if any
+	Origin         TxOrigin      // Nonce and submitter the transaction ID was derived from
+}
+
+func defaultExecOptions() ExecOptions {
+	return ExecOptions{
+		DeadlockPolicy: defaultDeadlockPolicy,
+		CrossShardMode: defaultCrossShardMode,
+		ConflictPolicy: defaultConflictPolicy,
+	}
+}
+
+// Apply the optional deadlock_policy, cross_shard_mode and conflict_policy
+// request fields on top of the server defaults
+func execOptionsFromRequest(deadlockPolicy, crossShardMode, conflictPolicy string) (ExecOptions, error) {
+	opts := defaultExecOptions()
+	if deadlockPolicy != "" {
+		if err := opts.DeadlockPolicy.Set(deadlockPolicy); err != nil {
+			return opts, err
+		}
+	}
+	if crossShardMode != "" {
+		if err := opts.CrossShardMode.Set(crossShardMode); err != nil {
+			return opts, err
+		}
+	}
+	if conflictPolicy != "" {
+		policy, err := conflictPolicyByName(conflictPolicy)
+		if err != nil {
+			return opts, err
+		}
+		opts.ConflictPolicy = policy
+	}
+	return opts, nil
+}
+
+// Commit metadata for a transaction run with these options
+func (opts ExecOptions) commitMeta(txID string, startedAt time.Time) CommitMeta {
+	return CommitMeta{TxID: txID, Priority: opts.Priority, StartedAt: startedAt, Policy: opts.ConflictPolicy}
+}
+
+// Take the block locks for a transaction, run commit, then release them
+func withBlockLocks(txID string, startedAt time.Time, opts ExecOptions, blocks []int, writes int, commit func() (int, error)) (int, error) {
+	lockManager.Begin(txID, startedAt, writes, opts.Priority, opts.DeadlockPolicy)
+	defer lockManager.Release(txID)
+
+	for _, block := range blocks {
+		if err := lockManager.Acquire(txID, block); err != nil {
+			log.Printf("💀 %s could not lock block %d: %v", txID, block, err)
+			return 0, err
+		}
+	}
+	if err := lockManager.Prepare(txID); err != nil {
+		log.Printf("💀 %s aborted before commit: %v", txID, err)
+		return 0, err
+	}
+	return commit()
+}
+
+// Acquire the source and target block locks, validate and commit the write
+// set, then release the locks. Returns the world state commit height.
+func commitTransfer(txID string, startedAt time.Time, opts ExecOptions, source, target int, reads []ReadEntry, writes []WriteEntry) (int, error) {
+	return withBlockLocks(txID, startedAt, opts, []int{source, target}, len(writes), func() (int, error) {
+		meta := opts.commitMeta(txID, startedAt)
+		if isCrossShard(source, target) {
+			return twoPhaseCommit(meta, reads, writes)
+		}
+		return worldState.Commit(meta, reads, writes)
+	})
+}
+
+// Lock the source and target blocks and merge a transfer's commutative ops
+// into the world state. Returns the commit height and the resolved writes.
+func commitCommutative(txID string, startedAt time.Time, opts ExecOptions, source, target int, ops []CommutativeOp) (int, []WriteEntry, error) {
+	var merged []WriteEntry
+	height, err := withBlockLocks(txID, startedAt, opts, []int{source, target}, len(ops), func() (int, error) {
+		height, writes, err := worldState.CommitOps(opts.commitMeta(txID, startedAt), nil, nil, ops)
+		merged = writes
+		return height, err
+	})
+	return height, merged, err
+}
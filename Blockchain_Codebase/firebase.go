@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
 	"time"
 	"cloud.google.com/go/firestore"
 	firebase "firebase.google.com/go/v4"
@@ -10,31 +13,80 @@ import (
 	"google.golang.org/api/option"
 )
 
-var firestoreClient *firestore.Client
+// Store backed by Firestore. Transaction logs are stored field by field, as
+// the dashboard reads them, through the background writer; blocks and
+// conflict records are stored as JSON documents keyed by index and ID.
+type FirestoreStore struct {
+	client *firestore.Client
+	writer *FirestoreWriter
+}
 
-func InitFirebase() {
+// Connect to Firestore with a service account. An empty project ID is taken
+// from the credentials file.
+func NewFirestoreStore(credentialsFile, projectID string) (*FirestoreStore, error) {
 	ctx := context.Background()
-	opt := option.WithCredentialsFile("csc4006-serviceAccount.json")
-	app, err := firebase.NewApp(ctx, &firebase.Config{ProjectID: "csc4006-7eb5a"}, opt)
-
+	opt := option.WithCredentialsFile(credentialsFile)
+	var config *firebase.Config
+	if projectID != "" {
+		config = &firebase.Config{ProjectID: projectID}
+	}
+	app, err := firebase.NewApp(ctx, config, opt)
 	if err != nil {
-		log.Fatalf("🔥 Failed to initialize Firebase: %v", err)
+		return nil, fmt.Errorf("initialize Firebase: %w", err)
 	}
 	log.Println("✅ Firebase initialized")
 
 	client, err := app.Firestore(ctx)
 	if err != nil {
-		log.Fatalf("❌ Failed to init Firestore client: %v", err)
+		return nil, fmt.Errorf("init Firestore client: %w", err)
 	}
-	firestoreClient = client
 	log.Println("📦 Firestore client ready")
+	s := &FirestoreStore{client: client}
+	s.writer = newFirestoreWriter(firestoreWriterConfig, s.writeTransactions)
+	return s, nil
+}
+
+func (s *FirestoreStore) Name() string { return "firestore" }
+
+func (s *FirestoreStore) Close() error {
+	s.writer.Close()
+	return s.client.Close()
+}
+
+// Queue the log for the background writer
+func (s *FirestoreStore) SaveTransaction(tx TransactionLog) error {
+	return s.writer.Enqueue(tx)
 }
 
-func SaveTransactionToFirestore(tx TransactionLog) {
+// Write a batch of logs. Documents are named after the log's clock and
+// transaction, so a retried batch overwrites rather than duplicates.
+func (s *FirestoreStore) writeTransactions(batch []TransactionLog) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
 
-	_, _, err := firestoreClient.Collection("transactions").Add(ctx, map[string]interface{}{
+	bw := s.client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(batch))
+	for _, tx := range batch {
+		doc := s.client.Collection("transactions").Doc(tx.HLC.String() + "-" + tx.TxID)
+		job, err := bw.Set(doc, transactionDocument(tx))
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fields of a transaction log document
+func transactionDocument(tx TransactionLog) map[string]interface{} {
+	return map[string]interface{}{
 		"txID":        tx.TxID,
 		"source":      tx.Source,
 		"target":      tx.Target,
@@ -44,31 +96,31 @@ func SaveTransactionToFirestore(tx TransactionLog) {
 		"finality":    tx.Finality,
 		"tps":         tx.TPS,
 		"timestamp":   tx.Timestamp,
+		"hlc":         tx.HLC.String(),
 		"propagation": tx.Propagation,
-	})
-
-	if err != nil {
-		log.Printf("❌ Failed to write transaction to Firestore: %v", err)
-	} else {
-		log.Println("✅ Transaction saved to Firestore")
+		"attempts":    tx.Attempts,
+		"outcome":     tx.Outcome,
+		"policy":      tx.Policy,
+		"mode":        tx.Mode,
+		"conflict":    tx.Conflict,
 	}
 }
 
 // Load a specific sytem after finishing
-func LoadTransactionsFromFirestore() []TransactionLog {
+func (s *FirestoreStore) LoadTransactions() ([]TransactionLog, error) {
 	ctx := context.Background()
 	var logs []TransactionLog
 
-	// Sort by Ascending Timestamp 
-	iter := firestoreClient.Collection("transactions").OrderBy("timestamp", firestore.Asc).Documents(ctx)
+	// Sort by Ascending Timestamp, then by clock below; older documents
+	// have no clock to order by
+	iter := s.client.Collection("transactions").OrderBy("timestamp", firestore.Asc).Documents(ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			log.Printf("❌ Error reading document: %v", err)
-			continue
+			return nil, err
 		}
 		data := doc.Data()
 		var tx TransactionLog
@@ -95,6 +147,12 @@ func LoadTransactionsFromFirestore() []TransactionLog {
 		if val, ok := data["timestamp"].(string); ok {
 			tx.Timestamp = val
 		}
+		tx.HLC = hlcFromTimestamp(tx.Timestamp)
+		if val, ok := data["hlc"].(string); ok {
+			if clock, err := ParseHLC(val); err == nil {
+				tx.HLC = clock
+			}
+		}
 		if val, ok := data["finality"].(float64); ok {
 			tx.Finality = val
 		}
@@ -104,9 +162,107 @@ func LoadTransactionsFromFirestore() []TransactionLog {
 		if val, ok := data["propagation"].(float64); ok {
 			tx.Propagation = val
 		}
+		if val, ok := data["attempts"].(int64); ok {
+			tx.Attempts = int(val)
+		}
+		if val, ok := data["outcome"].(string); ok {
+			tx.Outcome = val
+		}
+		if val, ok := data["policy"].(string); ok {
+			tx.Policy = val
+		}
+		if val, ok := data["mode"].(string); ok {
+			tx.Mode = val
+		}
+		if val, ok := data["conflict"].(string); ok {
+			tx.Conflict = val
+		}
 		logs = append(logs, tx)
 	}
+	sortLogsByHLC(logs)
+	return logs, nil
+}
+
+func (s *FirestoreStore) SaveBlock(block Block) error {
+	return s.setJSON("blocks", block.Index, block)
+}
+
+func (s *FirestoreStore) TruncateBlocks(height int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	docs, err := s.client.Collection("blocks").Where("seq", ">=", height).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if _, err := doc.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FirestoreStore) LoadBlocks() ([]Block, error) {
+	var blocks []Block
+	err := s.loadJSON("blocks", func(data []byte) error {
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		return nil
+	})
+	return blocks, err
+}
+
+func (s *FirestoreStore) SaveConflict(record ConflictRecord) error {
+	return s.setJSON("conflicts", record.ID, record)
+}
+
+func (s *FirestoreStore) LoadConflicts() ([]ConflictRecord, error) {
+	var records []ConflictRecord
+	err := s.loadJSON("conflicts", func(data []byte) error {
+		var record ConflictRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+// Store value as JSON in the document named after its sequence number,
+// replacing any earlier version
+func (s *FirestoreStore) setJSON(collection string, seq int, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	_, err = s.client.Collection(collection).Doc(strconv.Itoa(seq)).Set(ctx, map[string]interface{}{
+		"seq":  seq,
+		"data": string(data),
+	})
+	return err
+}
 
-	log.Printf("📦 Loaded %d transactions from Firestore\n", len(logs))
-	return logs
+// Pass every document's JSON to decode in sequence order
+func (s *FirestoreStore) loadJSON(collection string, decode func([]byte) error) error {
+	iter := s.client.Collection(collection).OrderBy("seq", firestore.Asc).Documents(context.Background())
+	defer iter.Stop()
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		data, _ := doc.Data()["data"].(string)
+		if err := decode([]byte(data)); err != nil {
+			return fmt.Errorf("%s/%s: %w", collection, doc.Ref.ID, err)
+		}
+	}
 }
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Transaction logs reach Firestore through a background writer, so
+// executing transactions never wait on the network. Logs are queued, up to
+// QueueSize, and written in batches of up to BatchSize, or whatever has
+// queued after FlushInterval. A failed batch is retried with exponential
+// backoff; if Firestore stays unreachable the batch is spilled to a local
+// file and written once a later batch gets through. Logs arriving while the
+// queue is full are dropped and counted.
+
+type WriterConfig struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+	Retry         RetryPolicy
+	SpillPath     string // Empty = batches that cannot be written are dropped
+}
+
+var firestoreWriterConfig = WriterConfig{
+	QueueSize:     1024,
+	BatchSize:     100,
+	FlushInterval: time.Second,
+	Retry:         RetryPolicy{MaxRetries: 5, Backoff: BackoffExponential, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second},
+	SpillPath:     "firestore-spill.jsonl",
+}
+
+var (
+	ErrWriterQueueFull = errors.New("Firestore write queue is full")
+	ErrWriterClosed    = errors.New("Firestore writer is closed")
+)
+
+// Counters of the background writer
+type WriterMetrics struct {
+	QueueDepth    int    `json:"queue_depth"`
+	QueueCapacity int    `json:"queue_capacity"`
+	Enqueued      int    `json:"enqueued"`
+	Written       int    `json:"written"`
+	Batches       int    `json:"batches"`
+	Retries       int    `json:"retries"`
+	FailedBatches int    `json:"failed_batches"` // Batches that ran out of retries
+	Spilled       int    `json:"spilled"`
+	SpillPending  int    `json:"spill_pending"` // Spilled logs not yet written
+	Replayed      int    `json:"replayed"`      // Spilled logs written later
+	Dropped       int    `json:"dropped"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+type FirestoreWriter struct {
+	WriterConfig
+	write func([]TransactionLog) error
+
+	mu     sync.RWMutex // Guards closed against sends on the closed queue
+	closed bool
+	queue  chan TransactionLog
+	done   chan struct{}
+
+	metricsMu sync.Mutex
+	metrics   WriterMetrics
+}
+
+// Start a writer that hands batches to write
+func newFirestoreWriter(cfg WriterConfig, write func([]TransactionLog) error) *FirestoreWriter {
+	w := &FirestoreWriter{
+		WriterConfig: cfg,
+		write:        write,
+		queue:        make(chan TransactionLog, max(cfg.QueueSize, 1)),
+		done:         make(chan struct{}),
+	}
+	w.BatchSize = max(w.BatchSize, 1)
+	if w.FlushInterval <= 0 {
+		w.FlushInterval = time.Second
+	}
+	w.metrics.QueueCapacity = cap(w.queue)
+	if pending := w.readSpill(); len(pending) > 0 {
+		w.metrics.SpillPending = len(pending)
+		log.Printf("📤 %d transaction logs spilled to %s are waiting for Firestore", len(pending), w.SpillPath)
+	}
+	go w.run()
+	return w
+}
+
+// Queue a log without blocking
+func (w *FirestoreWriter) Enqueue(entry TransactionLog) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.count(func(m *WriterMetrics) { m.Dropped++ })
+		return ErrWriterClosed
+	}
+	select {
+	case w.queue <- entry:
+		w.count(func(m *WriterMetrics) { m.Enqueued++ })
+		return nil
+	default:
+		w.count(func(m *WriterMetrics) { m.Dropped++ })
+		return ErrWriterQueueFull
+	}
+}
+
+// Stop accepting logs and write out everything queued
+func (w *FirestoreWriter) Close() {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	<-w.done
+}
+
+func (w *FirestoreWriter) Metrics() WriterMetrics {
+	w.metricsMu.Lock()
+	defer w.metricsMu.Unlock()
+	metrics := w.metrics
+	metrics.QueueDepth = len(w.queue)
+	return metrics
+}
+
+func (w *FirestoreWriter) count(update func(m *WriterMetrics)) {
+	w.metricsMu.Lock()
+	update(&w.metrics)
+	w.metricsMu.Unlock()
+}
+
+func (w *FirestoreWriter) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]TransactionLog, 0, w.BatchSize)
+	for {
+		select {
+		case entry, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) < w.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				w.replaySpill() // Idle: try the spilled logs again
+				continue
+			}
+		}
+		w.flush(batch)
+		batch = make([]TransactionLog, 0, w.BatchSize)
+	}
+}
+
+// Write a batch, spilling it if Firestore stays unreachable. Once a batch
+// gets through, spilled logs are sent after it.
+func (w *FirestoreWriter) flush(batch []TransactionLog) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := w.writeWithRetry(batch); err != nil {
+		w.spill(batch, err)
+		return
+	}
+	w.replaySpill()
+}
+
+// Write a batch, retrying with backoff up to Retry.MaxRetries times
+func (w *FirestoreWriter) writeWithRetry(batch []TransactionLog) error {
+	for attempt := 1; ; attempt++ {
+		err := w.write(batch)
+		if err == nil {
+			w.count(func(m *WriterMetrics) { m.Written += len(batch); m.Batches++ })
+			return nil
+		}
+		w.count(func(m *WriterMetrics) { m.LastError = err.Error() })
+		if attempt > w.Retry.MaxRetries {
+			w.count(func(m *WriterMetrics) { m.FailedBatches++ })
+			return err
+		}
+		delay := w.Retry.Delay(attempt)
+		w.count(func(m *WriterMetrics) { m.Retries++ })
+		log.Printf("🔁 Retrying Firestore batch of %d in %v (attempt %d/%d): %v",
+			len(batch), delay, attempt+1, w.Retry.MaxRetries+1, err)
+		time.Sleep(delay)
+	}
+}
+
+// Append a batch to the spill file
+func (w *FirestoreWriter) spill(batch []TransactionLog, cause error) {
+	if w.SpillPath == "" {
+		w.count(func(m *WriterMetrics) { m.Dropped += len(batch) })
+		log.Printf("❌ Dropped %d transaction logs, Firestore unreachable: %v", len(batch), cause)
+		return
+	}
+	var buf bytes.Buffer
+	for _, entry := range batch {
+		line, _ := json.Marshal(entry)
+		buf.Write(append(line, '\n'))
+	}
+	f, err := os.OpenFile(w.SpillPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = f.Write(buf.Bytes())
+		if err == nil {
+			err = f.Sync()
+		}
+		f.Close()
+	}
+	if err != nil {
+		w.count(func(m *WriterMetrics) { m.Dropped += len(batch) })
+		log.Printf("❌ Dropped %d transaction logs, Firestore unreachable (%v) and spilling failed: %v", len(batch), cause, err)
+		return
+	}
+	w.count(func(m *WriterMetrics) { m.Spilled += len(batch); m.SpillPending += len(batch) })
+	log.Printf("📥 Spilled %d transaction logs to %s, Firestore unreachable: %v", len(batch), w.SpillPath, cause)
+}
+
+// Spilled logs, oldest first
+func (w *FirestoreWriter) readSpill() []TransactionLog {
+	if w.SpillPath == "" {
+		return nil
+	}
+	f, err := os.Open(w.SpillPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []TransactionLog
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry TransactionLog
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("⚠️ Skipping corrupt spill line: %v", err)
+			continue // A torn final write after a crash
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Write spilled logs in batches, one attempt each. Whatever is left after
+// the first failure stays in the spill file.
+func (w *FirestoreWriter) replaySpill() {
+	if w.Metrics().SpillPending == 0 {
+		return
+	}
+	entries := w.readSpill()
+	sent := 0
+	for sent < len(entries) {
+		batch := entries[sent:min(sent+w.BatchSize, len(entries))]
+		if err := w.write(batch); err != nil {
+			w.count(func(m *WriterMetrics) { m.LastError = err.Error() })
+			break
+		}
+		sent += len(batch)
+	}
+	if sent == 0 && len(entries) > 0 {
+		return
+	}
+
+	var rest bytes.Buffer
+	for _, entry := range entries[sent:] {
+		line, _ := json.Marshal(entry)
+		rest.Write(append(line, '\n'))
+	}
+	if err := writeFileAtomic(w.SpillPath, rest.Bytes()); err != nil {
+		log.Printf("❌ Failed to rewrite %s: %v", w.SpillPath, err) // Sent logs may be written twice
+	}
+	w.count(func(m *WriterMetrics) {
+		m.Written += sent
+		m.Replayed += sent
+		m.SpillPending = len(entries) - sent
+	})
+	log.Printf("📤 Wrote %d spilled transaction logs to Firestore, %d left", sent, len(entries)-sent)
+}
+
+// API reporting the store backend and, for Firestore, the writer's queue
+func getStoreMetrics(c *gin.Context) {
+	response := gin.H{"store": store.Name()}
+	if fs, ok := store.(*FirestoreStore); ok {
+		response["writer"] = fs.writer.Metrics()
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// The genesis file declares what the chain starts with: its shards, the
+// organisations and the container prefixes that map to their shards, initial
+// account balances and the chain parameters. The genesis block is built
+// from it alone, so the same file always yields the same genesis hash.
+
+// Shard declared at genesis
+type GenesisShard struct {
+	ID   int    `json:"id" yaml:"id"`
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+}
+
+// Initial balance of a block's account
+type GenesisAccount struct {
+	Block   int   `json:"block" yaml:"block"`
+	Balance int64 `json:"balance" yaml:"balance"`
+}
+
+// Chain parameters; command-line flags take precedence over these
+type ChainParams struct {
+	MaxTransactionsPerBlock int    `json:"max_transactions_per_block" yaml:"max_transactions_per_block"`
+	MaxBlockBytes           int    `json:"max_block_bytes" yaml:"max_block_bytes"`
+	MaxBlockAge             string `json:"max_block_age" yaml:"max_block_age"`     // Go duration, e.g. "10s"
+	BeaconInterval          string `json:"beacon_interval" yaml:"beacon_interval"` // Go duration
+}
+
+type GenesisConfig struct {
+	ChainID   string           `json:"chain_id" yaml:"chain_id"`
+	Timestamp string           `json:"timestamp" yaml:"timestamp"` // RFC 3339, fixed so the block is reproducible
+	Shards    []GenesisShard   `json:"shards" yaml:"shards"`
+	Orgs      []Org            `json:"orgs" yaml:"orgs"`
+	Accounts  []GenesisAccount `json:"accounts" yaml:"accounts"`
+	Params    ChainParams      `json:"params" yaml:"params"`
+}
+
+// Transaction type of the entries in the genesis block
+const genesisTxType = "Genesis"
+
+var (
+	genesisFile   = "genesis.json"
+	genesisConfig = defaultGenesis()
+	genesisHash   = genesisBlock(genesisConfig).Hash
+)
+
+// Genesis used when no file is present; matches the built-in two-org setup
+func defaultGenesis() *GenesisConfig {
+	return &GenesisConfig{
+		ChainID:   "csc4006",
+		Timestamp: "2025-01-01T00:00:00Z",
+		Shards:    []GenesisShard{{ID: 0, Name: "Org1"}, {ID: 1, Name: "Org2"}},
+		Orgs: []Org{
+			{Name: "Org1", Domain: "org1.example.com", Prefix: "org1", Shard: 0},
+			{Name: "Org2", Domain: "org2.example.com", Prefix: "org2", Shard: 1},
+		},
+		Accounts: make([]GenesisAccount, 0),
+		Params: ChainParams{
+			MaxTransactionsPerBlock: maxTransactionsPerBlock,
+			MaxBlockBytes:           64 << 10,
+			MaxBlockAge:             "10s",
+			BeaconInterval:          "5s",
+		},
+	}
+}
+
+// Read a genesis file, JSON or, by extension, YAML. Fields the file leaves
+// out keep their default values. A missing default file is not an error.
+func readGenesis(path string, required bool) (*GenesisConfig, error) {
+	cfg := defaultGenesis()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		log.Printf("⚠️ No genesis file at %s, using the default genesis", path)
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, cfg)
+	default:
+		err = json.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (cfg *GenesisConfig) validate() error {
+	if cfg.ChainID == "" {
+		return errors.New("chain_id is required")
+	}
+	if _, err := time.Parse(time.RFC3339, cfg.Timestamp); err != nil {
+		return fmt.Errorf("timestamp: %w", err)
+	}
+	if len(cfg.Shards) == 0 {
+		return errors.New("at least one shard is required")
+	}
+	sort.Slice(cfg.Shards, func(i, j int) bool { return cfg.Shards[i].ID < cfg.Shards[j].ID })
+	for i, shard := range cfg.Shards {
+		if shard.ID != i {
+			return fmt.Errorf("shard IDs must run from 0 to %d without gaps", len(cfg.Shards)-1)
+		}
+	}
+	names := make(map[string]bool)
+	for _, org := range cfg.Orgs {
+		switch {
+		case org.Name == "" || org.Domain == "" || org.Prefix == "":
+			return fmt.Errorf("org %q needs a name, domain and prefix", org.Name)
+		case names[org.Name]:
+			return fmt.Errorf("org %q is declared twice", org.Name)
+		case org.Shard < 0 || org.Shard >= len(cfg.Shards):
+			return fmt.Errorf("org %q is assigned to unknown shard %d", org.Name, org.Shard)
+		}
+		names[org.Name] = true
+	}
+	sort.Slice(cfg.Accounts, func(i, j int) bool { return cfg.Accounts[i].Block < cfg.Accounts[j].Block })
+	for i, account := range cfg.Accounts {
+		if account.Block < 0 || account.Balance < 0 {
+			return fmt.Errorf("account of block %d: block and balance must not be negative", account.Block)
+		}
+		if i > 0 && cfg.Accounts[i-1].Block == account.Block {
+			return fmt.Errorf("account of block %d is declared twice", account.Block)
+		}
+	}
+	if cfg.Params.MaxTransactionsPerBlock < 0 || cfg.Params.MaxBlockBytes < 0 {
+		return errors.New("block limits must not be negative")
+	}
+	if _, err := time.ParseDuration(cfg.Params.MaxBlockAge); err != nil {
+		return fmt.Errorf("max_block_age: %w", err)
+	}
+	if _, err := time.ParseDuration(cfg.Params.BeaconInterval); err != nil {
+		return fmt.Errorf("beacon_interval: %w", err)
+	}
+	return nil
+}
+
+// Build the genesis block. It holds one transaction carrying the whole
+// configuration and one allocating each initial balance, so its hash
+// commits to everything in the file.
+func genesisBlock(cfg *GenesisConfig) Block {
+	config, _ := json.Marshal(cfg)
+	transactions := []Transaction{{
+		TransactionID: "genesis-config",
+		Timestamp:     cfg.Timestamp,
+		Data:          string(config),
+		Status:        "completed",
+		Type:          genesisTxType,
+		Outcome:       OutcomeCommitted,
+	}}
+	for _, account := range cfg.Accounts {
+		balance := strconv.FormatInt(account.Balance, 10)
+		transactions = append(transactions, Transaction{
+			TransactionID: "genesis-" + accountKey(account.Block),
+			Timestamp:     cfg.Timestamp,
+			Source:        account.Block,
+			Target:        account.Block,
+			Data:          balance,
+			Status:        "completed",
+			Type:          genesisTxType,
+			WriteSet:      []WriteEntry{{Key: accountKey(account.Block), Value: balance}},
+			Outcome:       OutcomeCommitted,
+		})
+	}
+
+	block := Block{
+		Index:        0,
+		Timestamp:    cfg.Timestamp,
+		ContainerID:  "genesis",
+		Transactions: transactions,
+		PreviousHash: "0",
+		Version:      1,
+		ShardID:      0,
+		Sealed:       true,
+	}
+	if len(cfg.Accounts) > 0 {
+		block.StateHeight = 1 // The allocations are the first world state commit
+	}
+	block.MerkleRoot = merkleRoot(transactions)
+	block.Hash = blockHash(&block)
+	return block
+}
+
+// Load the genesis file and apply its shards, organisations and parameters.
+// Parameters whose flag was given on the command line are left alone.
+func loadGenesis() error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	cfg, err := readGenesis(genesisFile, set["genesis"])
+	if err != nil {
+		return err
+	}
+
+	genesisConfig = cfg
+	genesisHash = genesisBlock(cfg).Hash
+	NumShards = len(cfg.Shards)
+	orgs = cfg.Orgs
+	if !set["block-max-txs"] {
+		blockProducer.MaxTransactions = cfg.Params.MaxTransactionsPerBlock
+	}
+	if !set["block-max-bytes"] {
+		blockProducer.MaxBytes = cfg.Params.MaxBlockBytes
+	}
+	if !set["block-max-age"] {
+		blockProducer.MaxAge, _ = time.ParseDuration(cfg.Params.MaxBlockAge)
+	}
+	if !set["beacon-interval"] {
+		beaconChain.Interval, _ = time.ParseDuration(cfg.Params.BeaconInterval)
+	}
+	log.Printf("🌱 Genesis %s for chain %s: %d shards, %d orgs, %d accounts", genesisHash[:12], cfg.ChainID, len(cfg.Shards), len(cfg.Orgs), len(cfg.Accounts))
+	return nil
+}
+
+// Start the chain from the genesis block, seeding the world state with the
+// initial balances unless it already holds commits. Caller must hold
+// BlockchainMu and the chain must be empty.
+func installGenesis() *Block {
+	block := genesisBlock(genesisConfig)
+	seedGenesisState(&block)
+	Blockchain = append(Blockchain, block)
+	log.Printf("🌱 Genesis block %s installed", block.Hash[:12])
+	ledgerStore.Changed()
+	return &Blockchain[0]
+}
+
+// Apply the genesis allocations to an empty world state
+func seedGenesisState(genesis *Block) {
+	worldState.mu.Lock()
+	defer worldState.mu.Unlock()
+	if worldState.height > 0 || len(genesis.Transactions) < 2 {
+		return
+	}
+	writes := make([]WriteEntry, 0, len(genesis.Transactions)-1)
+	for _, tx := range genesis.Transactions[1:] {
+		writes = append(writes, tx.WriteSet...)
+	}
+	worldState.apply(CommitMeta{TxID: "genesis"}, writes)
+}
+
+// Report the genesis hash on every response
+func GenesisMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Set("X-Genesis-Hash", genesisHash)
+		c.Next()
+	}
+}
+
+// API returning the genesis configuration, block and hash
+func getGenesis(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"genesis_hash": genesisHash,
+		"config":       genesisConfig,
+		"block":        genesisBlock(genesisConfig),
+	})
+}
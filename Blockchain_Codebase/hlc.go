@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hybrid logical clock. A timestamp is the wall clock in milliseconds plus
+// a logical counter that orders events within the same millisecond, so
+// every timestamp the node hands out is unique and strictly increasing, and
+// merging a timestamp received from elsewhere moves the clock past it.
+// Timestamps encode as fixed-width strings that sort in clock order.
+
+type HLC struct {
+	Wall    int64  // Unix milliseconds
+	Logical uint32 // Events within the same millisecond
+}
+
+// Highest wall time accepted from a remote timestamp ahead of our clock
+const maxClockDrift = time.Minute
+
+// Highest logical counter, the largest that fits the six digits of the
+// encoding. Past it the clock moves on to the next millisecond.
+const maxHLCLogical = 999999
+
+var (
+	ErrClockDrift   = errors.New("timestamp is too far ahead of the local clock")
+	ErrClockLogical = errors.New("timestamp logical counter is out of range")
+)
+
+func (h HLC) IsZero() bool { return h == HLC{} }
+
+// Next timestamp after h, carrying into the wall time when the logical
+// counter is spent
+func (h HLC) tick() HLC {
+	if h.Logical >= maxHLCLogical {
+		return HLC{Wall: h.Wall + 1}
+	}
+	return HLC{Wall: h.Wall, Logical: h.Logical + 1}
+}
+
+// Total order: wall time, then logical counter
+func (h HLC) Before(other HLC) bool {
+	return h.Wall < other.Wall || (h.Wall == other.Wall && h.Logical < other.Logical)
+}
+
+func (h HLC) String() string {
+	return fmt.Sprintf("%013d.%06d", h.Wall, h.Logical)
+}
+
+func (h HLC) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *HLC) UnmarshalText(text []byte) error {
+	parsed, err := ParseHLC(string(text))
+	if err != nil {
+		return err
+	}
+	*h = parsed
+	return nil
+}
+
+func ParseHLC(s string) (HLC, error) {
+	wall, logical, ok := strings.Cut(s, ".")
+	w, err1 := strconv.ParseInt(wall, 10, 64)
+	l, err2 := strconv.ParseUint(logical, 10, 32)
+	if !ok || err1 != nil || err2 != nil {
+		return HLC{}, fmt.Errorf("invalid hybrid logical clock %q", s)
+	}
+	return HLC{Wall: w, Logical: uint32(l)}, nil
+}
+
+// Clock position of an RFC 3339 timestamp, for records that predate the
+// clock. Unparseable timestamps sort first.
+func hlcFromTimestamp(timestamp string) HLC {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return HLC{}
+	}
+	return HLC{Wall: t.UnixMilli()}
+}
+
+type HybridClock struct {
+	mu   sync.Mutex
+	last HLC
+	now  func() time.Time
+}
+
+var hybridClock = &HybridClock{now: time.Now}
+
+// Timestamp a local event
+func (c *HybridClock) Now() HLC {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wall := c.now().UnixMilli(); wall > c.last.Wall {
+		c.last = HLC{Wall: wall}
+	} else {
+		c.last = c.last.tick()
+	}
+	return c.last
+}
+
+// Merge a timestamp received from another node or client, so every later
+// local event is ordered after it. Returns the timestamp of the receive.
+func (c *HybridClock) Update(remote HLC) (HLC, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wall := c.now().UnixMilli()
+	if remote.Wall-wall > maxClockDrift.Milliseconds() {
+		return c.last, fmt.Errorf("%w: %s", ErrClockDrift, remote)
+	}
+	if remote.Logical > maxHLCLogical {
+		return c.last, fmt.Errorf("%w: %s", ErrClockLogical, remote)
+	}
+
+	next := HLC{Wall: max(wall, c.last.Wall, remote.Wall)}
+	switch {
+	case next.Wall == c.last.Wall && next.Wall == remote.Wall:
+		next = HLC{Wall: next.Wall, Logical: max(c.last.Logical, remote.Logical)}.tick()
+	case next.Wall == c.last.Wall:
+		next = c.last.tick()
+	case next.Wall == remote.Wall:
+		next = remote.tick()
+	}
+	c.last = next
+	return next, nil
+}
+
+// Sort transaction logs by clock; equal clocks keep their order
+func sortLogsByHLC(logs []TransactionLog) {
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].HLC.Before(logs[j].HLC) })
+}
+
+// Merge the X-HLC request header, if any, and stamp the response with the
+// clock after the request
+func HLCMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if header := c.GetHeader("X-HLC"); header != "" {
+			remote, err := ParseHLC(header)
+			if err == nil {
+				_, err = hybridClock.Update(remote)
+			}
+			if err != nil {
+				log.Printf("⚠️ Rejected request clock %q: %v", header, err)
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		c.Writer.Header().Set("X-HLC", hybridClock.Now().String())
+		c.Next()
+	}
+}
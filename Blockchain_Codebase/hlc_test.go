@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHybridClockLogicalOverflow(t *testing.T) {
+	frozen := time.UnixMilli(1_700_000_000_000)
+	clock := &HybridClock{now: func() time.Time { return frozen }}
+	wall := frozen.UnixMilli()
+
+	if _, err := clock.Update(HLC{Wall: wall, Logical: 4294967295}); !errors.Is(err, ErrClockLogical) {
+		t.Fatalf("Update with an out-of-range logical = %v, want %v", err, ErrClockLogical)
+	}
+
+	got, err := clock.Update(HLC{Wall: wall, Logical: maxHLCLogical})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if want := (HLC{Wall: wall + 1}); got != want {
+		t.Fatalf("Update past the last logical = %s, want %s", got, want)
+	}
+
+	spent := HLC{Wall: got.Wall, Logical: maxHLCLogical}
+	clock.last = spent
+	next := clock.Now()
+	if want := (HLC{Wall: spent.Wall + 1}); next != want {
+		t.Fatalf("Now after %s = %s, want %s", spent, next, want)
+	}
+	if next.String() <= spent.String() {
+		t.Errorf("%s does not encode after %s", next, spent)
+	}
+}
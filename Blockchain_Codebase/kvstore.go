@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Embedded key-value store: a single file of JSON lines, each setting one
+// key of one bucket, or deleting it with a null value, fsynced as it is
+// written. Later lines win. Opening the store replays the file and, if it
+// holds overwritten or deleted entries, compacts it to one line per live
+// key.
+
+const (
+	kvTransactions = "transactions"
+	kvBlocks       = "blocks"
+	kvConflicts    = "conflicts"
+)
+
+type kvEntry struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value"`
+}
+
+type KVStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	buckets map[string]map[string]json.RawMessage
+}
+
+// Open the store at path, creating it if needed
+func OpenKVStore(path string) (*KVStore, error) {
+	s := &KVStore{path: path, buckets: make(map[string]map[string]json.RawMessage)}
+	lines, err := s.replay()
+	if err != nil {
+		return nil, err
+	}
+	if live := s.size(); lines > live {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+		log.Printf("🗜️ Compacted %s from %d to %d entries", path, lines, live)
+	}
+	s.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("📦 Key-value store %s ready (%d entries)", path, s.size())
+	return s, nil
+}
+
+// Read every entry in the file. Returns the number of lines read.
+func (s *KVStore) replay() (int, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		lines++
+		var entry kvEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			log.Printf("⚠️ Skipping corrupt store line %d: %v", lines, err)
+			continue // A torn final write after a crash
+		}
+		if bytes.Equal(entry.Value, []byte("null")) {
+			delete(s.bucket(entry.Bucket), entry.Key)
+		} else {
+			s.bucket(entry.Bucket)[entry.Key] = entry.Value
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Rewrite the file with only the live entries
+func (s *KVStore) compact() error {
+	var buf bytes.Buffer
+	for name, bucket := range s.buckets {
+		for key, value := range bucket {
+			line, err := json.Marshal(kvEntry{Bucket: name, Key: key, Value: value})
+			if err != nil {
+				return err
+			}
+			buf.Write(append(line, '\n'))
+		}
+	}
+	return writeFileAtomic(s.path, buf.Bytes())
+}
+
+func (s *KVStore) size() int {
+	n := 0
+	for _, bucket := range s.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+func (s *KVStore) bucket(name string) map[string]json.RawMessage {
+	if s.buckets[name] == nil {
+		s.buckets[name] = make(map[string]json.RawMessage)
+	}
+	return s.buckets[name]
+}
+
+// Set a key, or delete it if value is nil, and fsync it before returning.
+// Caller must hold s.mu.
+func (s *KVStore) put(bucket, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(kvEntry{Bucket: bucket, Key: key, Value: data})
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	if value == nil {
+		delete(s.bucket(bucket), key)
+	} else {
+		s.bucket(bucket)[key] = data
+	}
+	return nil
+}
+
+// Values of a bucket in key order
+func (s *KVStore) values(bucket string) []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.buckets[bucket]))
+	for key := range s.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]json.RawMessage, len(keys))
+	for i, key := range keys {
+		values[i] = s.buckets[bucket][key]
+	}
+	return values
+}
+
+// Fixed-width key, so keys sort in numeric order
+func kvSeq(n int) string {
+	return fmt.Sprintf("%012d", n)
+}
+
+func (s *KVStore) Name() string { return "kv" }
+
+func (s *KVStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *KVStore) SaveTransaction(entry TransactionLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(kvTransactions, kvSeq(len(s.buckets[kvTransactions])), entry)
+}
+
+func (s *KVStore) LoadTransactions() ([]TransactionLog, error) {
+	values := s.values(kvTransactions)
+	logs := make([]TransactionLog, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal(value, &logs[i]); err != nil {
+			return nil, fmt.Errorf("%s: transaction log %d: %w", s.path, i, err)
+		}
+	}
+	sortLogsByHLC(logs)
+	return logs, nil
+}
+
+func (s *KVStore) SaveBlock(block Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(kvBlocks, kvSeq(block.Index), block)
+}
+
+func (s *KVStore) TruncateBlocks(height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.buckets[kvBlocks] {
+		if key >= kvSeq(height) {
+			if err := s.put(kvBlocks, key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *KVStore) LoadBlocks() ([]Block, error) {
+	values := s.values(kvBlocks)
+	blocks := make([]Block, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal(value, &blocks[i]); err != nil {
+			return nil, fmt.Errorf("%s: block: %w", s.path, err)
+		}
+	}
+	return blocks, nil
+}
+
+func (s *KVStore) SaveConflict(record ConflictRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(kvConflicts, kvSeq(record.ID), record)
+}
+
+func (s *KVStore) LoadConflicts() ([]ConflictRecord, error) {
+	values := s.values(kvConflicts)
+	records := make([]ConflictRecord, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal(value, &records[i]); err != nil {
+			return nil, fmt.Errorf("%s: conflict record: %w", s.path, err)
+		}
+	}
+	return records, nil
+}
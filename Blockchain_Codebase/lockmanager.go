@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How the victim of a deadlock cycle is chosen
+type VictimPolicy string
+
+const (
+	VictimYoungest       VictimPolicy = "youngest"
+	VictimFewestWrites   VictimPolicy = "fewest-writes"
+	VictimLowestPriority VictimPolicy = "lowest-priority"
+)
+
+func (p *VictimPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *VictimPolicy) Set(value string) error {
+	switch VictimPolicy(value) {
+	case VictimYoungest, VictimFewestWrites, VictimLowestPriority:
+		*p = VictimPolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unknown victim policy %q (want youngest, fewest-writes or lowest-priority)", value)
+}
+
+// When the wait-for graph is searched for cycles
+type DetectionMode string
+
+const (
+	DetectOnRequest DetectionMode = "on-request"
+	DetectPeriodic  DetectionMode = "periodic"
+)
+
+func (m *DetectionMode) String() string {
+	return string(*m)
+}
+
+// Set implements flag.Value
+func (m *DetectionMode) Set(value string) error {
+	switch DetectionMode(value) {
+	case DetectOnRequest, DetectPeriodic:
+		*m = DetectionMode(value)
+		return nil
+	}
+	return fmt.Errorf("unknown detection mode %q (want on-request or periodic)", value)
+}
+
+// How lock conflicts are kept from turning into deadlocks. "detect" lets
+// transactions wait and breaks cycles afterwards; wound-wait and wait-die
+// prevent cycles up front by comparing transaction start timestamps.
+type DeadlockPolicy string
+
+const (
+	PolicyDetect    DeadlockPolicy = "detect"
+	PolicyWoundWait DeadlockPolicy = "wound-wait"
+	PolicyWaitDie   DeadlockPolicy = "wait-die"
+)
+
+func (p *DeadlockPolicy) String() string {
+	return string(*p)
+}
+
+// Set implements flag.Value
+func (p *DeadlockPolicy) Set(value string) error {
+	switch DeadlockPolicy(value) {
+	case PolicyDetect, PolicyWoundWait, PolicyWaitDie:
+		*p = DeadlockPolicy(value)
+		return nil
+	}
+	return fmt.Errorf("unknown deadlock policy %q (want detect, wound-wait or wait-die)", value)
+}
+
+// Returned by Acquire when the transaction has to give up its locks
+var (
+	ErrDeadlockVictim = errors.New("aborted as deadlock victim")
+	ErrWounded        = errors.New("wounded by an older transaction")
+	ErrDied           = errors.New("died requesting a lock held by an older transaction")
+)
+
+// Policy used when a request does not pick one
+var defaultDeadlockPolicy = PolicyDetect
+
+// Lock manager bookkeeping for one transaction
+type lockTxn struct {
+	id        string
+	startedAt time.Time
+	writes    int
+	priority  int
+	policy    DeadlockPolicy
+	aborted   bool
+	reason    error
+	prepared  bool // Past the point where it can be wounded
+}
+
+// Per-block exclusive lock manager with a wait-for graph. Each transaction
+// waits for at most one block at a time and each block has a single holder,
+// so the graph has out-degree <= 1 and cycles are found by following edges.
+type LockManager struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	holders map[int]string      // block index -> holding transaction
+	waiting map[string]int      // transaction -> block index it waits for
+	txns    map[string]*lockTxn // registered transactions
+
+	VictimPolicy VictimPolicy
+	Detection    DetectionMode
+	Interval     time.Duration
+}
+
+var lockManager = NewLockManager()
+
+func NewLockManager() *LockManager {
+	lm := &LockManager{
+		holders:      make(map[int]string),
+		waiting:      make(map[string]int),
+		txns:         make(map[string]*lockTxn),
+		VictimPolicy: VictimYoungest,
+		Detection:    DetectOnRequest,
+		Interval:     100 * time.Millisecond,
+	}
+	lm.cond = sync.NewCond(&lm.mu)
+	return lm
+}
+
+// Register a transaction before it requests locks. Age, write count and
+// priority are what the victim policies compare; startedAt should survive
+// retries so wound-wait and wait-die do not starve old transactions.
+func (lm *LockManager) Begin(txID string, startedAt time.Time, writes, priority int, policy DeadlockPolicy) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if policy == "" {
+		policy = defaultDeadlockPolicy
+	}
+	lm.txns[txID] = &lockTxn{id: txID, startedAt: startedAt, writes: writes, priority: priority, policy: policy}
+}
+
+// Called with all locks held, right before commit. Fails if the transaction
+// was wounded; afterwards it can no longer be wounded and requesters wait.
+func (lm *LockManager) Prepare(txID string) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	txn, ok := lm.txns[txID]
+	if !ok {
+		return fmt.Errorf("transaction %s not registered with lock manager", txID)
+	}
+	if txn.aborted {
+		return txn.reason
+	}
+	txn.prepared = true
+	return nil
+}
+
+// Block until the transaction holds the lock on the block, or it is aborted
+func (lm *LockManager) Acquire(txID string, block int) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	txn, ok := lm.txns[txID]
+	if !ok {
+		return fmt.Errorf("transaction %s not registered with lock manager", txID)
+	}
+
+	for {
+		if txn.aborted {
+			delete(lm.waiting, txID)
+			return txn.reason
+		}
+		holder, held := lm.holders[block]
+		if !held || holder == txID {
+			lm.holders[block] = txID
+			delete(lm.waiting, txID)
+			return nil
+		}
+
+		lm.waiting[txID] = block
+		switch txn.policy {
+		case PolicyWaitDie:
+			// Older requesters wait, younger ones die
+			if other := lm.txns[holder]; other != nil && !lm.older(txn, other) {
+				lm.abort(txn, ErrDied)
+				lm.recordPrevention(txn, other, block, txn)
+				continue
+			}
+		case PolicyWoundWait:
+			// Older requesters wound the holder, younger ones wait
+			if other := lm.txns[holder]; other != nil && lm.older(txn, other) && !other.aborted && !other.prepared {
+				lm.abort(other, ErrWounded)
+				lm.recordPrevention(txn, other, block, other)
+			}
+		default:
+			if lm.Detection == DetectOnRequest {
+				if cycle := lm.findCycle(txID); cycle != nil {
+					lm.resolve(cycle)
+					continue // We may have been picked as the victim
+				}
+			}
+		}
+		lm.cond.Wait()
+	}
+}
+
+// Whether a started before b, using the ID to break timestamp ties
+func (lm *LockManager) older(a, b *lockTxn) bool {
+	if !a.startedAt.Equal(b.startedAt) {
+		return a.startedAt.Before(b.startedAt)
+	}
+	return a.id < b.id
+}
+
+// Mark a transaction aborted and wake it if it is waiting. Caller must hold lm.mu.
+func (lm *LockManager) abort(txn *lockTxn, reason error) {
+	txn.aborted = true
+	txn.reason = reason
+	lm.cond.Broadcast()
+}
+
+// Record an abort made by wound-wait or wait-die. Caller must hold lm.mu.
+func (lm *LockManager) recordPrevention(requester, holder *lockTxn, block int, victim *lockTxn) {
+	recordConflict(ConflictRecord{
+		Kind:       ConflictDeadlock,
+		TxIDs:      []string{requester.id, holder.id},
+		Blocks:     []int{block},
+		Resolution: fmt.Sprintf("%s aborted %s", requester.policy, victim.id),
+		Policy:     string(requester.policy),
+		Detail:     fmt.Sprintf("%s requested block %d held by %s", requester.id, block, holder.id),
+	})
+}
+
+// Drop every lock held by the transaction and forget it
+func (lm *LockManager) Release(txID string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	for block, holder := range lm.holders {
+		if holder == txID {
+			delete(lm.holders, block)
+		}
+	}
+	delete(lm.waiting, txID)
+	delete(lm.txns, txID)
+	lm.cond.Broadcast()
+}
+
+// Periodically scan the wait-for graph for cycles
+func (lm *LockManager) RunDetector() {
+	ticker := time.NewTicker(lm.Interval)
+	for range ticker.C {
+		lm.mu.Lock()
+		waiters := make([]string, 0, len(lm.waiting))
+		for txID := range lm.waiting {
+			waiters = append(waiters, txID)
+		}
+		sort.Strings(waiters) // Deterministic scan order
+		for _, txID := range waiters {
+			if _, still := lm.waiting[txID]; !still {
+				continue
+			}
+			if cycle := lm.findCycle(txID); cycle != nil {
+				lm.resolve(cycle)
+			}
+		}
+		lm.mu.Unlock()
+	}
+}
+
+// Follow wait-for edges from start and return the cycle it leads into, if any.
+// Caller must hold lm.mu.
+func (lm *LockManager) findCycle(start string) []string {
+	seen := make(map[string]int)
+	var path []string
+	for current := start; ; {
+		if pos, ok := seen[current]; ok {
+			return path[pos:]
+		}
+		seen[current] = len(path)
+		path = append(path, current)
+
+		block, waits := lm.waiting[current]
+		if !waits {
+			return nil
+		}
+		holder, held := lm.holders[block]
+		if !held {
+			return nil
+		}
+		if txn := lm.txns[holder]; txn == nil || txn.aborted {
+			return nil // Holder is already on its way out
+		}
+		current = holder
+	}
+}
+
+// Abort the victim of a cycle and record the resolution. Caller must hold lm.mu.
+func (lm *LockManager) resolve(cycle []string) {
+	victim := lm.chooseVictim(cycle)
+	lm.abort(victim, ErrDeadlockVictim)
+
+	blocks := make([]int, 0, len(cycle))
+	for _, txID := range cycle {
+		blocks = append(blocks, lm.waiting[txID])
+	}
+	recordConflict(ConflictRecord{
+		Kind:       ConflictDeadlock,
+		TxIDs:      append([]string(nil), cycle...),
+		Blocks:     blocks,
+		Resolution: fmt.Sprintf("victim %s aborted (victim policy %s)", victim.id, lm.VictimPolicy),
+		Policy:     string(PolicyDetect),
+		Detail:     fmt.Sprintf("wait-for cycle %v", cycle),
+	})
+}
+
+// Pick the victim according to the configured policy, breaking ties by age
+// (youngest loses) and then by ID so the choice is deterministic.
+func (lm *LockManager) chooseVictim(cycle []string) *lockTxn {
+	var victim *lockTxn
+	for _, txID := range cycle {
+		txn := lm.txns[txID]
+		if victim == nil || lm.worseVictim(txn, victim) {
+			victim = txn
+		}
+	}
+	return victim
+}
+
+// Whether a is a better victim than b
+func (lm *LockManager) worseVictim(a, b *lockTxn) bool {
+	switch lm.VictimPolicy {
+	case VictimFewestWrites:
+		if a.writes != b.writes {
+			return a.writes < b.writes
+		}
+	case VictimLowestPriority:
+		if a.priority != b.priority {
+			return a.priority < b.priority
+		}
+	}
+	if !a.startedAt.Equal(b.startedAt) {
+		return a.startedAt.After(b.startedAt)
+	}
+	return a.id > b.id
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Merkle tree over a block's transactions. Leaves are the SHA-256 of each
+// transaction's JSON encoding and internal nodes the SHA-256 of their two
+// children, prefixed with 0x00 and 0x01 respectively so a leaf can never pass
+// for a node. A level with an odd number of nodes promotes its last node
+// unchanged; duplicating it would give [a,b,c] and [a,b,c,c] the same root.
+
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+// Sibling hash on the way from a leaf to the root
+type ProofStep struct {
+	Hash     string `json:"hash"`
+	Position string `json:"position"` // "left" or "right" of the running hash
+}
+
+// Header fields that commit to a block's contents
+type BlockHeader struct {
+	Index        int    `json:"index"`
+	Timestamp    string `json:"timestamp"`
+	HLC          HLC    `json:"hlc"`
+	PreviousHash string `json:"previous_hash"`
+	MerkleRoot   string `json:"merkle_root"`
+	Hash         string `json:"hash"`
+	Version      int    `json:"version"`
+	ShardID      int    `json:"shard_id"`
+}
+
+func headerOf(b *Block) BlockHeader {
+	return BlockHeader{
+		Index:        b.Index,
+		Timestamp:    b.Timestamp,
+		HLC:          b.HLC,
+		PreviousHash: b.PreviousHash,
+		MerkleRoot:   b.MerkleRoot,
+		Hash:         b.Hash,
+		Version:      b.Version,
+		ShardID:      b.ShardID,
+	}
+}
+
+func txLeafHash(tx Transaction) []byte {
+	data, _ := json.Marshal(tx)
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func hashPair(left, right []byte) []byte {
+	node := append(append([]byte{merkleNodePrefix}, left...), right...)
+	hash := sha256.Sum256(node)
+	return hash[:]
+}
+
+// Hashes of the next level up
+func merkleLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i]) // Odd node out moves up as is
+			break
+		}
+		next = append(next, hashPair(level[i], level[i+1]))
+	}
+	return next
+}
+
+func merkleLeaves(transactions []Transaction) [][]byte {
+	leaves := make([][]byte, len(transactions))
+	for i, tx := range transactions {
+		leaves[i] = txLeafHash(tx)
+	}
+	return leaves
+}
+
+// Merkle root of the transactions; the hash of nothing for an empty block
+func merkleRoot(transactions []Transaction) string {
+	return merkleRootOfLeaves(merkleLeaves(transactions))
+}
+
+func merkleRootOfLeaves(leaves [][]byte) string {
+	if len(leaves) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+	level := leaves
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// Sibling path from the transaction at position i up to the root
+func merkleProof(transactions []Transaction, i int) []ProofStep {
+	proof := make([]ProofStep, 0)
+	level := merkleLeaves(transactions)
+	for len(level) > 1 {
+		sibling, position := i+1, "right"
+		if i%2 == 1 {
+			sibling, position = i-1, "left"
+		}
+		if sibling < len(level) { // A promoted node has no sibling at this level
+			proof = append(proof, ProofStep{Hash: hex.EncodeToString(level[sibling]), Position: position})
+		}
+		level = merkleLevel(level)
+		i /= 2
+	}
+	return proof
+}
+
+// Fold a proof from the leaf up and compare with the root
+func verifyMerkleProof(leaf []byte, proof []ProofStep, root string) bool {
+	hash := leaf
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		if step.Position == "left" {
+			hash = hashPair(sibling, hash)
+		} else {
+			hash = hashPair(hash, sibling)
+		}
+	}
+	expected, err := hex.DecodeString(root)
+	return err == nil && bytes.Equal(hash, expected)
+}
+
+// API returning a transaction's Merkle inclusion proof and its block header
+func getTransactionProof(c *gin.Context) {
+	txID := c.Param("id")
+
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	for i := range Blockchain {
+		block := &Blockchain[i]
+		for j, tx := range block.Transactions {
+			if tx.TransactionID != txID {
+				continue
+			}
+			leaf := txLeafHash(tx)
+			proof := merkleProof(block.Transactions, j)
+			c.JSON(http.StatusOK, gin.H{
+				"transaction":  tx,
+				"leaf_hash":    hex.EncodeToString(leaf),
+				"leaf_index":   j,
+				"proof":        proof,
+				"block_header": headerOf(block),
+				"verified":     verifyMerkleProof(leaf, proof, block.MerkleRoot),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found in any block"})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func merkleTestTxs(n int) []Transaction {
+	txs := make([]Transaction, n)
+	for i := range txs {
+		txs[i] = Transaction{TransactionID: fmt.Sprintf("tx-%d", i), Source: i, Target: i + 1}
+	}
+	return txs
+}
+
+func TestMerkleRootDistinguishesDuplicatedLastNode(t *testing.T) {
+	txs := merkleTestTxs(3)
+	if merkleRoot(txs) == merkleRoot(append(txs, txs[2])) {
+		t.Fatal("[a,b,c] and [a,b,c,c] share a root")
+	}
+}
+
+func TestMerkleProofsVerify(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txs := merkleTestTxs(n)
+		root := merkleRoot(txs)
+		for i, tx := range txs {
+			if !verifyMerkleProof(txLeafHash(tx), merkleProof(txs, i), root) {
+				t.Errorf("%d transactions: proof of %d does not verify", n, i)
+			}
+		}
+		if n > 1 && verifyMerkleProof(txLeafHash(txs[0]), merkleProof(txs, 1), root) {
+			t.Errorf("%d transactions: proof of 1 verifies leaf 0", n)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Parallel optimistic execution of a batch (Block-STM style). Transactions
+// have a fixed position in the batch, the preset serial order. Workers
+// execute them speculatively against a multi-version memory, where a read of
+// key k by tx i sees the write of the highest tx j < i (or the world state).
+// After each round every read is re-validated; transactions whose reads
+// changed are re-executed together with the transactions that read from
+// them, and only the transactions from the lowest re-executed one onwards are
+// validated again. At the fixed point every read matches what the serial
+// order would have produced, so the committed result equals serial execution.
+// Writes of transactions due for re-execution are marked as estimates, and a
+// reader that hits an estimate waits for the lower transaction to finish
+// instead of speculating on a value that is known to be stale.
+
+// Number of workers used by the batch engine
+var batchWorkers = runtime.NumCPU()
+
+// Simulated execution cost of a single batch transaction
+const batchExecutionCost = 20 * time.Millisecond
+
+// One transaction of a batch, in serial order
+type BatchTx struct {
+	TransactionID string
+	Source        int
+	Target        int
+	Data          string
+	IsSharded     bool
+	Signer        SignedRequest
+	Origin        TxOrigin
+	Priority      int
+	Policy        ConflictPolicy // Nil uses the default conflict policy
+}
+
+// Where a speculative read got its value from: a lower transaction of the
+// batch (txIndex >= 0) at a given incarnation, or the world state.
+type mvVersion struct {
+	txIndex      int
+	incarnation  int
+	stateVersion int
+}
+
+type mvRead struct {
+	key     string
+	version mvVersion
+}
+
+type mvEntry struct {
+	incarnation int
+	value       string
+	estimate    bool // Writer is about to be re-executed
+}
+
+// Multi-version memory: key -> tx index -> latest write of that tx
+type mvMemory struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	data map[string]map[int]mvEntry
+}
+
+func newMVMemory() *mvMemory {
+	m := &mvMemory{data: make(map[string]map[int]mvEntry)}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// Read key as seen by tx index, falling back to the world state. Blocks
+// while the visible write is an estimate. Only lower transactions are waited
+// on, and work is dispatched in index order, so waiting cannot deadlock.
+func (m *mvMemory) read(key string, index int) (VersionedValue, mvVersion) {
+	m.mu.Lock()
+	best := -1
+	var entry mvEntry
+	for {
+		best = -1
+		for j, e := range m.data[key] {
+			if j < index && j > best {
+				best, entry = j, e
+			}
+		}
+		if best < 0 || !entry.estimate {
+			break
+		}
+		m.cond.Wait()
+	}
+	m.mu.Unlock()
+
+	if best >= 0 {
+		return VersionedValue{Value: entry.value}, mvVersion{txIndex: best, incarnation: entry.incarnation}
+	}
+	stored := worldState.Get(key)
+	return stored, mvVersion{txIndex: -1, stateVersion: stored.Version}
+}
+
+// Mark the writes of tx index as estimates ahead of its re-execution
+func (m *mvMemory) markEstimate(index int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, versions := range m.data {
+		if e, ok := versions[index]; ok {
+			e.estimate = true
+			versions[index] = e
+		}
+	}
+}
+
+// Replace the writes of tx index with a new incarnation's write set
+func (m *mvMemory) record(index, incarnation int, writes []WriteEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer m.cond.Broadcast()
+	for key, versions := range m.data {
+		delete(versions, index)
+		if len(versions) == 0 {
+			delete(m.data, key)
+		}
+	}
+	for _, w := range writes {
+		if m.data[w.Key] == nil {
+			m.data[w.Key] = make(map[int]mvEntry)
+		}
+		m.data[w.Key][index] = mvEntry{incarnation: incarnation, value: w.Value}
+	}
+}
+
+// Outcome of running a batch through the engine
+type BatchResult struct {
+	Transactions []Transaction `json:"transactions"`
+	OverBudget   []Transaction `json:"over_budget"` // Stopped by the transaction budget, not committed
+	Rounds       int           `json:"rounds"`
+	Executions   int           `json:"executions"`
+	ReExecutions int           `json:"re_executions"`
+	Duration     float64       `json:"duration_ms"`
+}
+
+// Per-transaction speculative state
+type batchSlot struct {
+	incarnation int
+	reads       []mvRead
+	writes      []WriteEntry
+	execTime    float64 // Milliseconds the latest incarnation took
+}
+
+// Execute the batch in parallel until every read is consistent with the
+// serial order, then commit it to the world state in that order.
+func executeBatch(batch []BatchTx) (*BatchResult, error) {
+	var lastErr error
+	for attempt := 1; attempt <= retryPolicy.MaxRetries+1; attempt++ {
+		result, err := runBatch(batch)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		var stale *StaleReadError
+		var locked *KeyLockedError
+		if errors.As(err, &locked) {
+			// A cross-shard transaction holds some of our keys; wait it out
+			time.Sleep(retryPolicy.Delay(attempt))
+			continue
+		}
+		if !errors.As(err, &stale) {
+			return nil, err
+		}
+		// Something outside the batch committed while it was executing
+		txIDs := []string{stale.WriterTxID}
+		for _, tx := range batch {
+			txIDs = append(txIDs, tx.TransactionID)
+		}
+		recordConflict(ConflictRecord{
+			Kind:       ConflictReadWrite,
+			TxIDs:      txIDs,
+			Keys:       []string{stale.Key},
+			Resolution: fmt.Sprintf("batch re-executed (attempt %d)", attempt),
+			Policy:     stale.Policy,
+			Detail:     err.Error(),
+		})
+		time.Sleep(retryPolicy.Delay(attempt))
+	}
+	return nil, lastErr
+}
+
+func runBatch(batch []BatchTx) (*BatchResult, error) {
+	start := time.Now()
+	memory := newMVMemory()
+	slots := make([]batchSlot, len(batch))
+	result := &BatchResult{}
+
+	execute := func(i int) {
+		begun := time.Now()
+		tx := batch[i]
+		slot := &slots[i]
+		var reads []mvRead
+		get := func(key string) VersionedValue {
+			value, version := memory.read(key, i)
+			reads = append(reads, mvRead{key: key, version: version})
+			return value
+		}
+		_, writes := executeTransfer(get, tx.Source, tx.Target, defaultTransferAmount)
+		time.Sleep(batchExecutionCost)
+
+		slot.incarnation++
+		slot.reads = reads
+		slot.writes = writes
+		slot.execTime = msSince(begun)
+		memory.record(i, slot.incarnation, writes)
+	}
+
+	validate := func(i int) bool {
+		for _, r := range slots[i].reads {
+			if _, current := memory.read(r.key, i); current != r.version {
+				return false
+			}
+		}
+		return true
+	}
+
+	toExecute := make([]int, len(batch))
+	for i := range toExecute {
+		toExecute[i] = i
+	}
+	for len(toExecute) > 0 {
+		result.Rounds++
+		result.Executions += len(toExecute)
+		if result.Rounds > 1 {
+			result.ReExecutions += len(toExecute)
+		}
+		parallelFor(toExecute, execute)
+
+		// Everything from the lowest re-executed transaction onwards may
+		// have read stale data; nothing below it can have changed.
+		toValidate := make([]int, 0, len(batch)-toExecute[0])
+		for i := toExecute[0]; i < len(batch); i++ {
+			toValidate = append(toValidate, i)
+		}
+		invalid := make([]bool, len(batch))
+		parallelFor(toValidate, func(i int) {
+			invalid[i] = !validate(i)
+		})
+
+		// A transaction that read from one being re-executed will see a new
+		// value too, so schedule it now rather than a round later
+		for _, i := range toValidate {
+			for _, r := range slots[i].reads {
+				if r.version.txIndex >= 0 && invalid[r.version.txIndex] {
+					invalid[i] = true
+					break
+				}
+			}
+		}
+
+		toExecute = toExecute[:0]
+		for _, i := range toValidate {
+			if invalid[i] {
+				toExecute = append(toExecute, i)
+				memory.markEstimate(i)
+			}
+		}
+	}
+
+	// Drop transactions over their budget before anything commits. Later
+	// transactions may have read their writes, so the rest run again.
+	var overBudget []Transaction
+	kept := make([]BatchTx, 0, len(batch))
+	for i, tx := range batch {
+		usage := ResourceUsage{PayloadBytes: len(tx.Data), Ops: len(slots[i].reads) + len(slots[i].writes), ExecTime: slots[i].execTime}
+		if checkTxBudget(usage) == nil {
+			kept = append(kept, tx)
+			continue
+		}
+		overBudget = append(overBudget, Transaction{
+			TransactionID: tx.TransactionID,
+			TxOrigin:      tx.Origin,
+			Source:        tx.Source,
+			Target:        tx.Target,
+			Data:          tx.Data,
+			Status:        statusBudgetExceeded,
+			Type:          map[bool]string{true: "Sharded", false: "Non-Sharded"}[tx.IsSharded],
+			Priority:      tx.Priority,
+			ExecTime:      msSince(start),
+			Attempts:      slots[i].incarnation,
+			Outcome:       OutcomeBudgetExceeded,
+			Usage:         usage,
+			Timestamp:     time.Now().Format(time.RFC3339),
+		})
+	}
+	if len(overBudget) > 0 {
+		rest, err := runBatch(kept)
+		if err != nil {
+			return nil, err
+		}
+		rest.OverBudget = append(overBudget, rest.OverBudget...)
+		rest.Rounds += result.Rounds
+		rest.Executions += result.Executions
+		rest.ReExecutions += result.ReExecutions
+		rest.Duration = msSince(start)
+		return rest, nil
+	}
+
+	// Reads served by the world state must still hold at commit time, or be
+	// let through by the transaction's conflict policy
+	metas := make([]CommitMeta, len(batch))
+	stateReads := make([][]ReadEntry, len(batch))
+	writes := make([][]WriteEntry, len(batch))
+	for i, slot := range slots {
+		tx := batch[i]
+		metas[i] = CommitMeta{TxID: tx.TransactionID, Priority: tx.Priority, StartedAt: start, Policy: tx.Policy}
+		writes[i] = slot.writes
+		seen := make(map[string]bool)
+		for _, r := range slot.reads {
+			if r.version.txIndex < 0 && !seen[r.key] {
+				seen[r.key] = true
+				stateReads[i] = append(stateReads[i], ReadEntry{Key: r.key, Version: r.version.stateVersion})
+			}
+		}
+	}
+	first, err := worldState.CommitBatch(metas, stateReads, writes)
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := float64(time.Since(start).Microseconds()) / 1000
+	for i, tx := range batch {
+		reads := make([]ReadEntry, 0, len(slots[i].reads))
+		for _, r := range slots[i].reads {
+			version := r.version.stateVersion
+			if r.version.txIndex >= 0 {
+				version = first + r.version.txIndex // Height the lower tx committed at
+			}
+			reads = append(reads, ReadEntry{Key: r.key, Version: version})
+		}
+		result.Transactions = append(result.Transactions, Transaction{
+			TransactionID: tx.TransactionID,
+			TxOrigin:      tx.Origin,
+			Source:        tx.Source,
+			Target:        tx.Target,
+			Version:       first + i,
+			Data:          tx.Data,
+			Status:        "completed",
+			Type:          map[bool]string{true: "Sharded", false: "Non-Sharded"}[tx.IsSharded],
+			Priority:      tx.Priority,
+			ExecTime:      elapsed,
+			ReadSet:       reads,
+			WriteSet:      slots[i].writes,
+			Attempts:      slots[i].incarnation,
+			Outcome:       OutcomeCommitted,
+			Usage:         ResourceUsage{PayloadBytes: len(tx.Data), Ops: len(reads) + len(slots[i].writes), ExecTime: slots[i].execTime},
+			SignerKeyID:   tx.Signer.KeyID,
+			Signature:     tx.Signer.Signature,
+			Timestamp:     time.Now().Format(time.RFC3339),
+		})
+	}
+	result.Duration = elapsed
+	return result, nil
+}
+
+// Run fn over indices on up to batchWorkers goroutines and wait for all
+func parallelFor(indices []int, fn func(i int)) {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(1, min(batchWorkers, len(indices))); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(i)
+			}
+		}()
+	}
+	for _, i := range indices {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// Run a submitted batch and record every transaction's outcome
+func processBatch(batch []BatchTx) {
+	result, err := executeBatch(batch)
+	if err != nil {
+		log.Printf("❌ Batch of %d transactions failed: %v", len(batch), err)
+		for _, tx := range batch {
+			txWAL.Abort(tx.TransactionID, "aborted", err.Error())
+		}
+		TransactionMu.Lock()
+		for _, tx := range batch {
+			transactionStatus[tx.TransactionID] = "aborted"
+			delete(TransactionPool, tx.TransactionID)
+		}
+		TransactionMu.Unlock()
+		return
+	}
+
+	conflictPolicy := make(map[string]string, len(batch))
+	for _, tx := range batch {
+		conflictPolicy[tx.TransactionID] = CommitMeta{Policy: tx.Policy}.policy().Name()
+	}
+
+	for _, tx := range result.OverBudget {
+		reason := checkTxBudget(tx.Usage).Error()
+		log.Printf("⚠️ Transaction %s %s in its batch: %s", tx.TransactionID, tx.Outcome, reason)
+		txWAL.Abort(tx.TransactionID, tx.Outcome, reason)
+		TransactionMu.Lock()
+		transactionStatus[tx.TransactionID] = tx.Outcome
+		delete(TransactionPool, tx.TransactionID)
+		TransactionMu.Unlock()
+
+		recordTransactionLog(TransactionLog{
+			TxID:      tx.TransactionID,
+			Source:    tx.Source,
+			Target:    tx.Target,
+			Message:   tx.Data,
+			Type:      tx.Type,
+			ExecTime:  tx.ExecTime,
+			Timestamp: tx.Timestamp,
+			Attempts:  tx.Attempts,
+			Outcome:   tx.Outcome,
+			Conflict:  conflictPolicy[tx.TransactionID],
+		})
+	}
+
+	for _, tx := range result.Transactions {
+		txWAL.Append(walRecord{Stage: walCommit, Tx: tx})
+	}
+
+	tps := math.Round(float64(len(result.Transactions))/(result.Duration/1000)*100) / 100
+	for _, tx := range result.Transactions {
+		TransactionMu.Lock()
+		delete(TransactionPool, tx.TransactionID)
+		TransactionMu.Unlock()
+
+		tx.HLC = hybridClock.Now()
+		BlockchainMu.Lock()
+		TransactionMu.Lock()
+		if transactionStatus[tx.TransactionID] == statusReverted {
+			TransactionMu.Unlock()
+			BlockchainMu.Unlock()
+			log.Printf("⏪ Transaction %s was reverted while its batch committed", tx.TransactionID)
+			continue
+		}
+		blockProducer.add(tx)
+		transactionStatus[tx.TransactionID] = "completed"
+		TransactionMu.Unlock()
+		BlockchainMu.Unlock()
+
+		// Batches hold only same-shard transfers, so there is no cross-shard mode to log
+		recordTransactionLog(TransactionLog{
+			TxID:      tx.TransactionID,
+			Source:    tx.Source,
+			Target:    tx.Target,
+			Message:   tx.Data,
+			Type:      tx.Type,
+			ExecTime:  tx.ExecTime,
+			Finality:  tx.ExecTime,
+			Timestamp: tx.Timestamp,
+			HLC:       tx.HLC,
+			TPS:       tps,
+			Attempts:  tx.Attempts,
+			Outcome:   tx.Outcome,
+			Conflict:  conflictPolicy[tx.TransactionID],
+		})
+	}
+
+	log.Printf("⚡ Batch of %d committed in %.2f ms (%d rounds, %d executions, %d re-executions)",
+		len(result.Transactions), result.Duration, result.Rounds, result.Executions, result.ReExecutions)
+}
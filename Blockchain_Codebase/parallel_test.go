@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Give the test an empty world state, restoring the global one after it
+func useFreshWorldState(t *testing.T) {
+	t.Helper()
+	state := worldState
+	t.Cleanup(func() { worldState = state })
+	worldState = NewWorldState()
+}
+
+// Apply the batch one transfer at a time, the serial order runBatch must match
+func serialBalances(seed map[string]string, batch []BatchTx) map[string]string {
+	state := make(map[string]VersionedValue)
+	for key, value := range seed {
+		state[key] = VersionedValue{Value: value, Version: 1}
+	}
+	get := func(key string) VersionedValue { return state[key] }
+	for i, tx := range batch {
+		_, writes := executeTransfer(get, tx.Source, tx.Target, defaultTransferAmount)
+		for _, w := range writes {
+			state[w.Key] = VersionedValue{Value: w.Value, Version: i + 2}
+		}
+	}
+	balances := make(map[string]string, len(state))
+	for key, value := range state {
+		balances[key] = value.Value
+	}
+	return balances
+}
+
+func TestRunBatchMatchesSerialOrder(t *testing.T) {
+	transfers := func(pairs ...[2]int) []BatchTx {
+		batch := make([]BatchTx, len(pairs))
+		for i, p := range pairs {
+			batch[i] = BatchTx{TransactionID: fmt.Sprintf("tx-%d", i), Source: p[0], Target: p[1]}
+		}
+		return batch
+	}
+	tests := []struct {
+		name  string
+		seed  map[string]string
+		batch []BatchTx
+	}{
+		{"disjoint", nil, transfers([2]int{1, 2}, [2]int{3, 4}, [2]int{5, 6})},
+		{"same source", map[string]string{"acct-1": "10"}, transfers([2]int{1, 2}, [2]int{1, 3}, [2]int{1, 4}, [2]int{1, 5})},
+		{"chain", nil, transfers([2]int{1, 2}, [2]int{2, 3}, [2]int{3, 4}, [2]int{4, 1})},
+		{"cycle", map[string]string{"acct-1": "5", "acct-2": "5"}, transfers([2]int{1, 2}, [2]int{2, 1}, [2]int{1, 2}, [2]int{2, 1}, [2]int{1, 2})},
+		{"hot key", nil, transfers([2]int{1, 9}, [2]int{2, 9}, [2]int{3, 9}, [2]int{9, 4}, [2]int{5, 9}, [2]int{9, 6})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFreshWorldState(t)
+			var seed []WriteEntry
+			for key, value := range tt.seed {
+				seed = append(seed, WriteEntry{Key: key, Value: value})
+			}
+			if len(seed) > 0 {
+				worldState.Commit(CommitMeta{TxID: "seed"}, nil, seed)
+			}
+
+			result, err := runBatch(tt.batch)
+			if err != nil {
+				t.Fatalf("runBatch: %v", err)
+			}
+			if len(result.Transactions) != len(tt.batch) {
+				t.Fatalf("committed %d transactions, want %d", len(result.Transactions), len(tt.batch))
+			}
+			want := serialBalances(tt.seed, tt.batch)
+			got := worldState.Snapshot()
+			if len(got) != len(want) {
+				t.Fatalf("state has %d keys, want %d", len(got), len(want))
+			}
+			for key, value := range want {
+				if got[key].Value != value {
+					t.Errorf("%s = %q, want %q", key, got[key].Value, value)
+				}
+			}
+			for i, tx := range result.Transactions {
+				if tx.TransactionID != tt.batch[i].TransactionID || tx.Version != result.Transactions[0].Version+i {
+					t.Errorf("transaction %d committed as %s at %d, out of serial order", i, tx.TransactionID, tx.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestCommitBatchUsesEachTransactionsPolicy(t *testing.T) {
+	useFreshWorldState(t)
+	worldState.Commit(CommitMeta{TxID: "writer", Priority: 5}, nil, []WriteEntry{{Key: "acct-1", Value: "1"}})
+
+	// Each batch read acct-1 before the writer committed
+	stale := [][]ReadEntry{{{Key: "acct-1", Version: 0}}}
+	writes := [][]WriteEntry{{{Key: "acct-1", Value: "2"}}}
+	for _, tt := range []struct {
+		meta  CommitMeta
+		allow bool
+	}{
+		{CommitMeta{TxID: "first", Priority: 9}, false},
+		{CommitMeta{TxID: "low", Priority: 1, Policy: priorityBased{}}, false},
+		{CommitMeta{TxID: "high", Priority: 9, Policy: priorityBased{}}, true},
+	} {
+		_, err := worldState.CommitBatch([]CommitMeta{tt.meta}, stale, writes)
+		if allowed := err == nil; allowed != tt.allow {
+			t.Errorf("%s committed = %v, want %v (err %v)", tt.meta.TxID, allowed, tt.allow, err)
+		}
+	}
+	if got := worldState.Get("acct-1"); got.TxID != "high" || got.WriterPriority != 9 {
+		t.Errorf("acct-1 = %+v, want the write of high at priority 9", got)
+	}
+}
+
+func TestRunBatchDropsTransactionsOverBudget(t *testing.T) {
+	useFreshWorldState(t)
+	budget := txBudget
+	t.Cleanup(func() { txBudget = budget })
+	txBudget.MaxPayloadBytes = 4
+
+	// tx-1 reads the balance tx-0 writes, so it must run again without it
+	batch := []BatchTx{
+		{TransactionID: "tx-0", Source: 1, Target: 2, Data: "over budget"},
+		{TransactionID: "tx-1", Source: 2, Target: 3},
+	}
+	result, err := runBatch(batch)
+	if err != nil {
+		t.Fatalf("runBatch: %v", err)
+	}
+	if len(result.OverBudget) != 1 || result.OverBudget[0].TransactionID != "tx-0" || result.OverBudget[0].Outcome != OutcomeBudgetExceeded {
+		t.Fatalf("over budget = %+v, want tx-0", result.OverBudget)
+	}
+	if len(result.Transactions) != 1 || result.Transactions[0].TransactionID != "tx-1" {
+		t.Fatalf("committed %+v, want only tx-1", result.Transactions)
+	}
+	want := serialBalances(nil, batch[1:])
+	got := worldState.Snapshot()
+	if len(got) != len(want) {
+		t.Fatalf("state has %d keys, want %d", len(got), len(want))
+	}
+	for key, value := range want {
+		if got[key].Value != value {
+			t.Errorf("%s = %q, want %q", key, got[key].Value, value)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The ledger, the history of shard assignments and every transaction status
+// are saved to the ledger file whenever a transaction commits or the chain
+// changes, and loaded back at startup. A save writes a temp file next to
+// the ledger file and renames it over it, so a crash leaves either the old
+// or the new snapshot, never a torn one. Each save also copies the blocks
+// that changed to the store. The world state is not saved; it is rebuilt
+// from the ledger at startup.
+
+// Contents of the ledger file
+type LedgerSnapshot struct {
+	GenesisHash      string            `json:"genesis_hash"`
+	SavedAt          HLC               `json:"saved_at"`
+	Blocks           []Block           `json:"blocks"`
+	ShardAssignments []shardAssignment `json:"shard_assignments"` // Oldest first
+	Statuses         map[string]string `json:"statuses"`
+}
+
+type LedgerStore struct {
+	Path    string // Empty disables the ledger file
+	mu      sync.Mutex
+	changed chan struct{}
+	synced  map[int]string // Block index -> fingerprint of the copy in the store
+}
+
+var ledgerStore = &LedgerStore{Path: blockchainFile, changed: make(chan struct{}, 1)}
+
+// Schedule a save. Never blocks, so it may be called with any lock held;
+// changes made before the save starts are coalesced into it.
+func (s *LedgerStore) Changed() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Save the ledger after every change
+func (s *LedgerStore) Run() {
+	for range s.changed {
+		if err := s.Save(); err != nil {
+			log.Printf("❌ Failed to save ledger to %s: %v", s.Path, err)
+		}
+	}
+}
+
+// Snapshot the node, write it to Path and bring the store's blocks up to
+// date. The write-ahead log then drops what the snapshot settled.
+func (s *LedgerStore) Save() error {
+	s.mu.Lock() // A later snapshot must not be overwritten by an earlier one
+	defer s.mu.Unlock()
+	mark := txWAL.Mark() // Taken first, so every record before it predates the snapshot
+	snapshot := captureLedger()
+	if s.Path != "" {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.Path, data); err != nil {
+			return err
+		}
+	}
+	if err := s.syncStore(snapshot.Blocks); err != nil {
+		return err
+	}
+	if s.Path == "" {
+		return nil // Statuses were not saved; the log still needs them
+	}
+	if err := txWAL.Compact(mark, snapshot.Statuses); err != nil {
+		log.Printf("⚠️ Failed to compact write-ahead log: %v", err)
+	}
+	return nil
+}
+
+// What a block's stored copy must match: its contents and its shard
+func blockFingerprint(block Block) string {
+	return fmt.Sprintf("%s/%d/%t", block.Hash, block.ShardID, block.Sealed)
+}
+
+// Remember that the store already holds these blocks
+func (s *LedgerStore) markSynced(blocks []Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = make(map[int]string, len(blocks))
+	for _, block := range blocks {
+		s.synced[block.Index] = blockFingerprint(block)
+	}
+}
+
+// Save the blocks that changed since the last sync and delete those the
+// chain no longer has. Caller must hold s.mu.
+func (s *LedgerStore) syncStore(blocks []Block) error {
+	if s.synced == nil {
+		s.synced = make(map[int]string)
+	}
+	if len(s.synced) > len(blocks) {
+		if err := store.TruncateBlocks(len(blocks)); err != nil {
+			return err
+		}
+		for index := range s.synced {
+			if index >= len(blocks) {
+				delete(s.synced, index)
+			}
+		}
+	}
+	for _, block := range blocks {
+		fingerprint := blockFingerprint(block)
+		if s.synced[block.Index] == fingerprint {
+			continue
+		}
+		if err := store.SaveBlock(block); err != nil {
+			return err
+		}
+		s.synced[block.Index] = fingerprint
+	}
+	return nil
+}
+
+// Copy the ledger, shard assignments and statuses in one consistent view.
+// Statuses are read under BlockchainMu, the same order commits take the
+// locks in, so every ledger transaction is already marked completed.
+func captureLedger() LedgerSnapshot {
+	snapshot := LedgerSnapshot{GenesisHash: genesisHash, SavedAt: hybridClock.Now(), Statuses: make(map[string]string)}
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	snapshot.Blocks = append([]Block(nil), Blockchain...)
+
+	shardAssignmentsMu.Lock()
+	snapshot.ShardAssignments = append([]shardAssignment(nil), shardAssignments...)
+	shardAssignmentsMu.Unlock()
+
+	TransactionMu.Lock()
+	for txID, status := range transactionStatus {
+		snapshot.Statuses[txID] = status
+	}
+	TransactionMu.Unlock()
+	return snapshot
+}
+
+// Write data to a temp file in the same directory, sync it and rename it
+// over path
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Read a ledger file, or a bare chain exported from GET /blockchain
+func readLedgerFile(path string) (*LedgerSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &LedgerSnapshot{Statuses: make(map[string]string)}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &snapshot.Blocks)
+	} else {
+		err = json.Unmarshal(data, snapshot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a ledger file or chain export: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// Load the saved ledger into the empty node, verifying every block as it
+// goes. Returns false if there is no ledger file yet. Transactions that
+// were still running when the node stopped are marked aborted, so they can
+// be submitted again. Caller must hold BlockchainMu.
+func (s *LedgerStore) Load() (bool, error) {
+	if s.Path == "" {
+		return false, nil
+	}
+	snapshot, err := readLedgerFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("⚠️ No ledger at %s, starting from genesis", s.Path)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(snapshot.Blocks) == 0 {
+		return false, nil
+	}
+	if snapshot.GenesisHash != "" && snapshot.GenesisHash != genesisHash {
+		return false, fmt.Errorf("%s was built from genesis %s, but the genesis file gives %s", s.Path, snapshot.GenesisHash, genesisHash)
+	}
+
+	report := verifyChain(snapshot.Blocks, verifyContext{statuses: snapshot.Statuses, genesis: genesisHash})
+	if !report.Valid {
+		return false, fmt.Errorf("%s failed verification: %s", s.Path, report.summary())
+	}
+
+	Blockchain = snapshot.Blocks
+	seedGenesisState(&Blockchain[0])
+
+	shardAssignmentsMu.Lock()
+	shardAssignments = snapshot.ShardAssignments
+	shardAssignmentsMu.Unlock()
+
+	interrupted := 0
+	TransactionMu.Lock()
+	for txID, status := range snapshot.Statuses {
+		if status == "pending" {
+			status = "aborted"
+			interrupted++
+		}
+		transactionStatus[txID] = status
+	}
+	TransactionMu.Unlock()
+
+	if _, err := hybridClock.Update(snapshot.SavedAt); err != nil {
+		log.Printf("⚠️ Clock not advanced past the saved ledger: %v", err)
+	}
+	log.Printf("📂 Loaded %d blocks and %d transaction statuses from %s (%d interrupted transactions aborted)",
+		len(Blockchain), len(snapshot.Statuses), s.Path, interrupted)
+	return true, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Who a commit belongs to, as far as conflict resolution is concerned
+type CommitMeta struct {
+	TxID      string
+	Priority  int
+	StartedAt time.Time
+	Policy    ConflictPolicy
+}
+
+// Decides, inside the commit path, whether a transaction whose read of a
+// key has since been overwritten may still commit. Returning false aborts
+// it with a StaleReadError.
+type ConflictPolicy interface {
+	Name() string
+	AllowStale(incoming CommitMeta, current VersionedValue) bool
+}
+
+// The first transaction to commit wins; later ones with stale reads abort
+type firstCommitterWins struct{}
+
+func (firstCommitterWins) Name() string { return "first-committer-wins" }
+
+func (firstCommitterWins) AllowStale(CommitMeta, VersionedValue) bool { return false }
+
+// Every commit goes through and overwrites whatever was there
+type lastWriterWins struct{}
+
+func (lastWriterWins) Name() string { return "last-writer-wins" }
+
+func (lastWriterWins) AllowStale(CommitMeta, VersionedValue) bool { return true }
+
+// A stale transaction commits only if it outranks the current writer
+type priorityBased struct{}
+
+func (priorityBased) Name() string { return "priority" }
+
+func (priorityBased) AllowStale(incoming CommitMeta, current VersionedValue) bool {
+	return incoming.Priority > current.WriterPriority
+}
+
+// A stale transaction commits only if it started after the current writer,
+// so writes are applied in timestamp order
+type timestampOrdering struct{}
+
+func (timestampOrdering) Name() string { return "timestamp-ordering" }
+
+func (timestampOrdering) AllowStale(incoming CommitMeta, current VersionedValue) bool {
+	return incoming.StartedAt.UnixNano() > current.WriterTS
+}
+
+var conflictPolicies = map[string]ConflictPolicy{
+	firstCommitterWins{}.Name(): firstCommitterWins{},
+	lastWriterWins{}.Name():     lastWriterWins{},
+	priorityBased{}.Name():      priorityBased{},
+	timestampOrdering{}.Name():  timestampOrdering{},
+}
+
+// Policy used when a request does not pick one
+var defaultConflictPolicy ConflictPolicy = firstCommitterWins{}
+
+func conflictPolicyByName(name string) (ConflictPolicy, error) {
+	if policy, ok := conflictPolicies[name]; ok {
+		return policy, nil
+	}
+	names := make([]string, 0, len(conflictPolicies))
+	for n := range conflictPolicies {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown conflict policy %q (want %s)", name, strings.Join(names, ", "))
+}
+
+// Policy a commit will be judged by, falling back to the server default
+func (m CommitMeta) policy() ConflictPolicy {
+	if m.Policy == nil {
+		return defaultConflictPolicy
+	}
+	return m.Policy
+}
+
+// Record every stale read a policy let through, once the commit succeeded
+func recordOverrides(meta CommitMeta, overrides []*StaleReadError, writes []WriteEntry) {
+	for _, stale := range overrides {
+		recordConflict(ConflictRecord{
+			Kind:       staleReadKind(stale, writes),
+			TxIDs:      []string{meta.TxID, stale.WriterTxID},
+			Keys:       []string{stale.Key},
+			Blocks:     []int{blockForKey(stale.Key)},
+			Resolution: fmt.Sprintf("committed %s over stale read", meta.TxID),
+			Policy:     stale.Policy,
+			Detail:     stale.Error(),
+		})
+	}
+}
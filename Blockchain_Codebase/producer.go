@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Committed transactions are appended to the open block at the tip of the
+// chain. Once it holds MaxTransactions, would grow past MaxBytes, or has been
+// open for MaxAge, the producer seals it and opens a new block linked to it.
+type BlockProducer struct {
+	MaxTransactions int           // 0 = no count limit
+	MaxBytes        int           // Encoded size of the block's transactions, 0 = no limit
+	MaxAge          time.Duration // 0 = no time limit
+}
+
+var blockProducer = &BlockProducer{
+	MaxTransactions: maxTransactionsPerBlock,
+	MaxBytes:        64 << 10,
+	MaxAge:          10 * time.Second,
+}
+
+func txSize(tx Transaction) int {
+	data, _ := json.Marshal(tx)
+	return len(data)
+}
+
+// Append a committed transaction to the open block and return the index of
+// the block it landed in
+func (p *BlockProducer) Add(tx Transaction) int {
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	return p.add(tx)
+}
+
+// Add for callers that already hold BlockchainMu
+func (p *BlockProducer) add(tx Transaction) int {
+	if tx.HLC.IsZero() {
+		tx.HLC = hybridClock.Now()
+	}
+	tip := p.openBlock()
+	if p.MaxBytes > 0 && len(tip.Transactions) > 0 {
+		size := txSize(tx)
+		for _, existing := range tip.Transactions {
+			size += txSize(existing)
+		}
+		if size > p.MaxBytes {
+			p.rollover("byte limit")
+			tip = &Blockchain[len(Blockchain)-1]
+		}
+	}
+
+	// Seal early rather than let the block grow past its budget
+	if len(tip.Transactions) > 0 && blockBudget.check("block", tip.Usage.plus(tx.Usage)) != nil {
+		p.rollover("block budget")
+		tip = &Blockchain[len(Blockchain)-1]
+	}
+
+	tip.Transactions = append(tip.Transactions, tx)
+	tip.Usage = tip.Usage.plus(tx.Usage)
+	sealFrom(len(Blockchain) - 1)
+	index := tip.Index
+
+	recordInShardChain(tx)
+
+	if p.MaxTransactions > 0 && len(tip.Transactions) >= p.MaxTransactions {
+		p.rollover("transaction limit")
+	}
+	ledgerStore.Changed()
+	return index
+}
+
+// The transaction also goes to the producer of its source block's shard.
+// Caller must hold BlockchainMu.
+func recordInShardChain(tx Transaction) {
+	shardID := tx.Source % NumShards
+	if source := findBlockByIndex(tx.Source); source != nil {
+		shardID = source.ShardID
+	}
+	shardChain(shardID).Submit(tx)
+}
+
+// The open block at the tip, opening one if the tip is sealed or the chain
+// is empty. Caller must hold BlockchainMu.
+func (p *BlockProducer) openBlock() *Block {
+	if len(Blockchain) == 0 || Blockchain[len(Blockchain)-1].Sealed {
+		return appendBlock("", len(Blockchain)%NumShards)
+	}
+	return &Blockchain[len(Blockchain)-1]
+}
+
+// Seal the open block and open the next one. Caller must hold BlockchainMu.
+func (p *BlockProducer) rollover(reason string) {
+	sealTip(reason)
+	appendBlock("", len(Blockchain)%NumShards)
+}
+
+// Re-hash the tip one last time and mark it sealed. Caller must hold
+// BlockchainMu.
+func sealTip(reason string) {
+	if len(Blockchain) == 0 || Blockchain[len(Blockchain)-1].Sealed {
+		return
+	}
+	sealFrom(len(Blockchain) - 1)
+	tip := &Blockchain[len(Blockchain)-1]
+	tip.Sealed = true
+	tip.StateHeight = worldState.Height()
+	log.Printf("📦 Sealed block %d with %d transactions (%s)", tip.Index, len(tip.Transactions), reason)
+	pruneRollbackState()
+}
+
+// Seal main-chain blocks that have been open longer than MaxAge. Empty
+// blocks stay open so an idle node does not fill the chain with them. Shard
+// chains seal their own blocks.
+func (p *BlockProducer) Run() {
+	if p.MaxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(p.MaxAge/4, 100*time.Millisecond))
+	defer ticker.Stop()
+	for range ticker.C {
+		BlockchainMu.Lock()
+		if n := len(Blockchain); n > 0 && !Blockchain[n-1].Sealed && len(Blockchain[n-1].Transactions) > 0 {
+			opened, err := time.Parse(time.RFC3339, Blockchain[n-1].Timestamp)
+			if err == nil && time.Since(opened) >= p.MaxAge {
+				p.rollover("time limit")
+			}
+		}
+		BlockchainMu.Unlock()
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Receipt-based cross-shard transfers. The source shard commits the debit
+// together with an outbox entry describing the receipt; the target shard
+// later commits the credit together with an inbox entry for the same
+// receipt. A receipt is consumed only if its inbox key has never been
+// written, and MVCC validation rejects a second consumer that raced the
+// first, so every receipt is credited exactly once.
+
+const (
+	receiptPending  = "pending"
+	receiptConsumed = "consumed"
+)
+
+// Cross-shard receipt emitted by a debit on the source shard
+type Receipt struct {
+	ID          string    `json:"id"`
+	TxID        string    `json:"tx_id"`
+	Source      int       `json:"source"`
+	Target      int       `json:"target"`
+	SourceShard int       `json:"source_shard"`
+	TargetShard int       `json:"target_shard"`
+	Amount      int64     `json:"amount"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	CreatedAt   time.Time `json:"created_at"`
+	HLC         HLC       `json:"hlc"` // Hybrid logical clock when emitted
+	ConsumedAt  time.Time `json:"consumed_at,omitempty"`
+	Version     int       `json:"version,omitempty"` // Height of the credit commit
+}
+
+// World state keys recording a receipt on each side
+func outboxKey(id string) string { return "outbox-" + id }
+func inboxKey(id string) string  { return "inbox-" + id }
+
+// Inspectable queue of receipts with one consumer per target shard
+type ReceiptQueue struct {
+	mu       sync.Mutex
+	receipts map[string]*Receipt
+	done     map[string]chan struct{}
+	inboxes  []chan string
+	start    sync.Once
+}
+
+var receiptQueue = &ReceiptQueue{
+	receipts: make(map[string]*Receipt),
+	done:     make(map[string]chan struct{}),
+}
+
+// Start one consumer goroutine per shard
+func (q *ReceiptQueue) Start() {
+	q.start.Do(func() {
+		q.inboxes = make([]chan string, NumShards)
+		for i := range q.inboxes {
+			q.inboxes[i] = make(chan string, 1024)
+			go q.consume(i)
+		}
+	})
+}
+
+// Queue a receipt whose debit has committed
+func (q *ReceiptQueue) Emit(receipt Receipt) {
+	q.Start()
+	q.mu.Lock()
+	if _, exists := q.receipts[receipt.ID]; exists {
+		q.mu.Unlock()
+		return
+	}
+	receipt.Status = receiptPending
+	q.receipts[receipt.ID] = &receipt
+	q.done[receipt.ID] = make(chan struct{})
+	q.mu.Unlock()
+
+	log.Printf("📨 Receipt %s emitted: shard %d → shard %d", receipt.ID, receipt.SourceShard, receipt.TargetShard)
+	q.inboxes[receipt.TargetShard] <- receipt.ID
+}
+
+// Block until the receipt has been consumed and return the credit height
+func (q *ReceiptQueue) Wait(id string) int {
+	q.mu.Lock()
+	done := q.done[id]
+	q.mu.Unlock()
+	if done == nil {
+		return 0
+	}
+	<-done
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.receipts[id].Version
+}
+
+// Consumer loop for one target shard
+func (q *ReceiptQueue) consume(shardID int) {
+	for id := range q.inboxes[shardID] {
+		q.mu.Lock()
+		receipt := *q.receipts[id]
+		q.mu.Unlock()
+
+		version := consumeReceipt(&receipt)
+
+		q.mu.Lock()
+		stored := q.receipts[id]
+		stored.Status = receiptConsumed
+		stored.ConsumedAt = time.Now()
+		stored.Attempts = receipt.Attempts
+		stored.Version = version
+		close(q.done[id])
+		q.mu.Unlock()
+	}
+}
+
+// Credit the target for a receipt, retrying until it commits. Returns the
+// credit's commit height, or 0 if the receipt had already been consumed.
+func consumeReceipt(receipt *Receipt) int {
+	creditTxID := receipt.ID + "-credit"
+	hybridClock.Update(receipt.HLC) // The credit happens after the debit that emitted it
+	for attempt := 1; ; attempt++ {
+		receipt.Attempts = attempt
+		reads, writes, consumed := executeCredit(worldState.Get, *receipt)
+		if consumed {
+			log.Printf("📭 Receipt %s already consumed, skipping", receipt.ID)
+			return 0
+		}
+
+		// Simulate the receipt travelling to the target shard
+		time.Sleep(time.Duration(10+rand.Intn(15)) * time.Millisecond)
+
+		// Credits are always judged first-committer-wins: letting a stale
+		// credit through could consume a receipt twice
+		opts := defaultExecOptions()
+		opts.ConflictPolicy = firstCommitterWins{}
+		version, err := withBlockLocks(creditTxID, receipt.CreatedAt, opts, []int{receipt.Target}, len(writes), func() (int, error) {
+			return worldState.Commit(opts.commitMeta(creditTxID, receipt.CreatedAt), reads, writes)
+		})
+		if err == nil {
+			log.Printf("📬 Receipt %s consumed on shard %d (attempt %d)", receipt.ID, receipt.TargetShard, attempt)
+			return version
+		}
+		delay := retryPolicy.Delay(attempt)
+		log.Printf("🔁 Retrying receipt %s in %v: %v", receipt.ID, delay, err)
+		time.Sleep(delay)
+	}
+}
+
+// Debit the source and write the outbox entry for the receipt
+func executeDebit(get func(key string) VersionedValue, receipt Receipt) ([]ReadEntry, []WriteEntry) {
+	srcKey := accountKey(receipt.Source)
+	src := get(srcKey)
+	body, _ := json.Marshal(receipt)
+
+	reads := []ReadEntry{{Key: srcKey, Version: src.Version}}
+	writes := []WriteEntry{
+		{Key: srcKey, Value: strconv.FormatInt(parseBalance(src.Value)-receipt.Amount, 10)},
+		{Key: outboxKey(receipt.ID), Value: string(body)},
+	}
+	return reads, writes
+}
+
+// Credit the target and mark the receipt consumed in its inbox. Reports
+// consumed=true if the inbox already records the receipt.
+func executeCredit(get func(key string) VersionedValue, receipt Receipt) ([]ReadEntry, []WriteEntry, bool) {
+	tgtKey, inKey := accountKey(receipt.Target), inboxKey(receipt.ID)
+	tgt, inbox := get(tgtKey), get(inKey)
+	if inbox.Version != 0 {
+		return nil, nil, true
+	}
+
+	reads := []ReadEntry{
+		{Key: tgtKey, Version: tgt.Version},
+		{Key: inKey, Version: inbox.Version},
+	}
+	writes := []WriteEntry{
+		{Key: tgtKey, Value: strconv.FormatInt(parseBalance(tgt.Value)+receipt.Amount, 10)},
+		{Key: inKey, Value: receiptConsumed},
+	}
+	return reads, writes, false
+}
+
+// New receipt for a cross-shard transfer
+func newReceipt(txID string, source, target int, amount int64) Receipt {
+	return Receipt{
+		ID:          txID,
+		TxID:        txID,
+		Source:      source,
+		Target:      target,
+		SourceShard: participantForBlock(source),
+		TargetShard: participantForBlock(target),
+		Amount:      amount,
+		CreatedAt:   time.Now(),
+		HLC:         hybridClock.Now(),
+	}
+}
+
+// Lock the source block and commit the debit with its outbox entry, then
+// emit the receipt to the target shard
+func commitDebit(txID string, startedAt time.Time, opts ExecOptions, receipt Receipt, reads []ReadEntry, writes []WriteEntry) (int, error) {
+	version, err := withBlockLocks(txID, startedAt, opts, []int{receipt.Source}, len(writes), func() (int, error) {
+		return worldState.Commit(opts.commitMeta(txID, startedAt), reads, writes)
+	})
+	if err != nil {
+		return 0, err
+	}
+	receiptQueue.Emit(receipt)
+	return version, nil
+}
+
+// Re-emit receipts whose debit committed but whose credit never did, e.g.
+// after a restart with a restored world state
+func recoverReceipts() {
+	for key, value := range worldState.Snapshot() {
+		if !strings.HasPrefix(key, "outbox-") {
+			continue
+		}
+		var receipt Receipt
+		if err := json.Unmarshal([]byte(value.Value), &receipt); err != nil {
+			log.Printf("⚠️ Skipping unreadable receipt %s: %v", key, err)
+			continue
+		}
+		if worldState.Get(inboxKey(receipt.ID)).Version == 0 {
+			receiptQueue.Emit(receipt)
+		}
+	}
+}
+
+// API to inspect the receipt queue, filtered by ?status= and ?shard=
+func getReceipts(c *gin.Context) {
+	status := c.Query("status")
+	shardFilter := -1
+	if v := c.Query("shard"); v != "" {
+		shardID, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid shard %q", v)})
+			return
+		}
+		shardFilter = shardID
+	}
+
+	receiptQueue.mu.Lock()
+	receipts := make([]Receipt, 0, len(receiptQueue.receipts))
+	pending := 0
+	for _, r := range receiptQueue.receipts {
+		if r.Status == receiptPending {
+			pending++
+		}
+		if status != "" && r.Status != status {
+			continue
+		}
+		if shardFilter >= 0 && r.SourceShard != shardFilter && r.TargetShard != shardFilter {
+			continue
+		}
+		receipts = append(receipts, *r)
+	}
+	receiptQueue.mu.Unlock()
+
+	sort.Slice(receipts, func(i, j int) bool { return receipts[i].CreatedAt.Before(receipts[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{
+		"pending":  pending,
+		"receipts": receipts,
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// At startup the node is rebuilt from what it persisted: the ledger comes
+// from the ledger file, or from the store's blocks if there is no file, and
+// is verified before it is used. Transactions left in flight are finished
+// or aborted from the write-ahead log, the world state and shard chains are
+// replayed from the ledger, and transaction logs and conflict records come
+// back from the store. Logs are then checked against the ledger.
+
+// Outcome of rebuilding the node at startup
+type RestoreReport struct {
+	Source       string       `json:"source"` // Where the ledger came from
+	Blocks       int          `json:"blocks"`
+	Transactions int          `json:"transactions"` // In the ledger, genesis excluded
+	StateHeight  int          `json:"state_height"`
+	Logs         int          `json:"logs"`
+	Conflicts    int          `json:"conflicts"`
+	Issues       []TxMismatch `json:"issues"` // Block is -1 for transactions missing from the ledger
+}
+
+var restoreReport = &RestoreReport{Source: "genesis", Issues: make([]TxMismatch, 0)}
+
+// Rebuild the node from the ledger file, the write-ahead log and the store.
+// Fails if the ledger does not verify.
+func restoreNode() (*RestoreReport, error) {
+	report := &RestoreReport{Issues: make([]TxMismatch, 0)}
+	stored, err := store.LoadBlocks()
+	if err != nil {
+		return nil, fmt.Errorf("load blocks from the %s store: %w", store.Name(), err)
+	}
+
+	BlockchainMu.Lock()
+	report.Source, err = loadLedger(stored)
+	if err == nil {
+		for _, block := range Blockchain {
+			for _, tx := range block.Transactions {
+				if tx.Type != genesisTxType {
+					recordInShardChain(tx)
+				}
+			}
+		}
+		keyRegistry.rememberNonces(Blockchain)
+	}
+	BlockchainMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	ledgerStore.markSynced(stored)
+
+	recoverTransactions() // finish or abort transactions in flight at the last stop
+	report.StateHeight = rebuildWorldState()
+	distributeBlocksToShards()
+
+	logs, err := store.LoadTransactions()
+	if err != nil {
+		return nil, fmt.Errorf("load transactions from the %s store: %w", store.Name(), err)
+	}
+	conflicts, err := store.LoadConflicts()
+	if err != nil {
+		return nil, fmt.Errorf("load conflicts from the %s store: %w", store.Name(), err)
+	}
+	transactionLogsMu.Lock()
+	transactionLogs = logs
+	transactionLogsMu.Unlock()
+	conflictsMu.Lock()
+	concurrencyConflicts = conflicts
+	conflictsMu.Unlock()
+
+	BlockchainMu.Lock()
+	blocks := append([]Block(nil), Blockchain...)
+	BlockchainMu.Unlock()
+	report.Blocks = len(blocks)
+	report.Logs = len(logs)
+	report.Conflicts = len(conflicts)
+	report.Issues = reconcileLogs(blocks, logs)
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.Type != genesisTxType {
+				report.Transactions++
+			}
+		}
+	}
+
+	// Order new events after everything restored
+	latest := blocks[len(blocks)-1].HLC
+	if n := len(logs); n > 0 && latest.Before(logs[n-1].HLC) {
+		latest = logs[n-1].HLC
+	}
+	if _, err := hybridClock.Update(latest); err != nil {
+		log.Printf("⚠️ Clock not advanced past the restored state: %v", err)
+	}
+
+	for _, issue := range report.Issues {
+		log.Printf("⚠️ Transaction %s: %s", issue.TransactionID, issue.Problem)
+	}
+	log.Printf("📂 Restored node from %s: %d blocks, %d transactions, state height %d, %d logs, %d conflicts, %d issues",
+		report.Source, report.Blocks, report.Transactions, report.StateHeight, report.Logs, report.Conflicts, len(report.Issues))
+	restoreReport = report
+	return report, nil
+}
+
+// Put the saved ledger in place: the ledger file if there is one, else the
+// store's blocks once they verify, else a fresh genesis. Returns where the
+// ledger came from. Caller must hold BlockchainMu.
+func loadLedger(stored []Block) (string, error) {
+	loaded, err := ledgerStore.Load()
+	if err != nil {
+		return "", err
+	}
+	if loaded {
+		if len(stored) > len(Blockchain) {
+			log.Printf("⚠️ The %s store holds %d blocks, the ledger file %d; using the ledger file", store.Name(), len(stored), len(Blockchain))
+		}
+		return "ledger file", nil
+	}
+	if len(stored) > 0 {
+		if chain := verifyChain(stored, verifyContext{genesis: genesisHash}); !chain.Valid {
+			return "", fmt.Errorf("blocks in the %s store failed verification: %s", store.Name(), chain.summary())
+		}
+		Blockchain = append([]Block(nil), stored...)
+		log.Printf("📂 Loaded %d blocks from the %s store", len(Blockchain), store.Name())
+		return store.Name() + " store", nil
+	}
+	installGenesis()
+	return "genesis", nil
+}
+
+// Height a ledger transaction's write set committed at. In receipts mode
+// that is the debit's, not the credit's the transaction is versioned with.
+func replayHeight(tx Transaction) int {
+	if tx.DebitVersion > 0 {
+		return tx.DebitVersion
+	}
+	return tx.Version
+}
+
+// Replay the write sets of the ledger's transactions into an empty world
+// state, each at the height it originally committed at. Returns the new
+// state height.
+func rebuildWorldState() int {
+	BlockchainMu.Lock()
+	genesis := Blockchain[0]
+	var txs []Transaction
+	for _, block := range Blockchain[1:] {
+		for _, tx := range block.Transactions {
+			if len(tx.WriteSet) > 0 {
+				txs = append(txs, tx)
+			}
+		}
+	}
+	BlockchainMu.Unlock()
+	sort.SliceStable(txs, func(i, j int) bool { return replayHeight(txs[i]) < replayHeight(txs[j]) })
+
+	worldState.mu.Lock()
+	worldState.data = make(map[string]VersionedValue)
+	worldState.height = 0
+	worldState.undo = nil
+	worldState.pruned = 0
+	worldState.mu.Unlock()
+	seedGenesisState(&genesis)
+
+	worldState.mu.Lock()
+	defer worldState.mu.Unlock()
+	for _, tx := range txs {
+		if height := replayHeight(tx); height > worldState.height {
+			worldState.height = height - 1 // Heights of aborted commits stay unused
+		}
+		worldState.apply(CommitMeta{TxID: tx.TransactionID, Priority: tx.Priority}, tx.WriteSet)
+	}
+	return worldState.height
+}
+
+// Check the transaction logs against the ledger and fill in statuses the
+// node lost. A transaction logged as committed must be in the ledger, one
+// in the ledger must not be logged as anything else, and both must agree
+// on the transfer.
+func reconcileLogs(blocks []Block, logs []TransactionLog) []TxMismatch {
+	issues := make([]TxMismatch, 0)
+	inLedger := make(map[string]int)
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			inLedger[tx.TransactionID] = block.Index
+		}
+	}
+	latest := make(map[string]TransactionLog) // Logs are in clock order
+	var order []string
+	for _, entry := range logs {
+		if _, seen := latest[entry.TxID]; !seen {
+			order = append(order, entry.TxID)
+		}
+		latest[entry.TxID] = entry
+	}
+
+	issues = append(issues, verifyChain(blocks, verifyContext{logs: latest}).Transactions...)
+
+	TransactionMu.Lock()
+	defer TransactionMu.Unlock()
+	for txID := range inLedger {
+		if status, ok := transactionStatus[txID]; !ok || status == "pending" {
+			transactionStatus[txID] = "completed"
+		}
+	}
+	for _, txID := range order {
+		entry := latest[txID]
+		index, committed := inLedger[txID]
+		switch {
+		case entry.Outcome == OutcomeCommitted && !committed && transactionStatus[txID] != statusReverted:
+			issues = append(issues, TxMismatch{TransactionID: txID, Block: -1, Problem: "logged as committed but missing from the ledger"})
+		case entry.Outcome != "" && entry.Outcome != OutcomeCommitted && committed:
+			issues = append(issues, TxMismatch{TransactionID: txID, Block: index, Problem: fmt.Sprintf("in the ledger but logged as %s", entry.Outcome)})
+		case !committed && entry.Outcome != "":
+			if _, ok := transactionStatus[txID]; !ok {
+				transactionStatus[txID] = entry.Outcome
+			}
+		}
+	}
+	return issues
+}
+
+// API reporting how the node was restored at startup
+func getRestoreReport(c *gin.Context) {
+	c.JSON(http.StatusOK, restoreReport)
+}
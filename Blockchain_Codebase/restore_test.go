@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// World state as served by GET /state
+func fetchState(t *testing.T) map[string]VersionedValue {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/state", getWorldState)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+	var body struct {
+		State map[string]VersionedValue `json:"state"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET /state: %v", err)
+	}
+	return body.State
+}
+
+func TestRebuildWorldStateMatchesStateBeforeRestart(t *testing.T) {
+	chain, state := Blockchain, worldState
+	t.Cleanup(func() { Blockchain, worldState = chain, state })
+	worldState = NewWorldState()
+	Blockchain = []Block{genesisBlock(genesisConfig)}
+	rebuildWorldState()
+
+	commit := func(txID string, source, target int) Transaction {
+		tx := Transaction{TransactionID: txID, Source: source, Target: target}
+		tx.ReadSet, tx.WriteSet = executeTransfer(worldState.Get, source, target, defaultTransferAmount)
+		var err error
+		if tx.Version, err = worldState.Commit(CommitMeta{TxID: txID}, tx.ReadSet, tx.WriteSet); err != nil {
+			t.Fatalf("commit %s: %v", txID, err)
+		}
+		return tx
+	}
+
+	// A receipts-mode transfer debits block 1, a local transfer then spends
+	// from block 1, and only after that is the first transfer credited and
+	// recorded, versioned with its credit
+	debit := Transaction{TransactionID: "tx-debit", Source: 1, Target: 2}
+	debit.ReadSet, debit.WriteSet = executeTransfer(worldState.Get, 1, 2, defaultTransferAmount)
+	debit.WriteSet = debit.WriteSet[:1]
+	debitHeight, err := worldState.Commit(CommitMeta{TxID: debit.TransactionID}, debit.ReadSet, debit.WriteSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := commit("tx-local", 1, 3)
+	credit, err := worldState.Commit(CommitMeta{TxID: "tx-debit-credit"}, nil, []WriteEntry{{Key: accountKey(2), Value: "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	debit.DebitVersion, debit.Version = debitHeight, credit
+	Blockchain = append(Blockchain, Block{Index: 1, Transactions: []Transaction{local, debit}})
+
+	before := fetchState(t)
+	rebuildWorldState()
+	after := fetchState(t)
+
+	for _, tx := range []Transaction{local, debit} {
+		for _, w := range tx.WriteSet {
+			if before[w.Key] != after[w.Key] {
+				t.Errorf("%s after restart = %+v, want %+v", w.Key, after[w.Key], before[w.Key])
+			}
+		}
+	}
+}
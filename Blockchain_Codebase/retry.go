@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Final outcome of a transaction after all execution attempts
+const (
+	OutcomeCommitted      = "committed"
+	OutcomeAborted        = "aborted"
+	OutcomeBudgetExceeded = statusBudgetExceeded
+)
+
+// Backoff applied between retries of a conflicting transaction
+type BackoffStrategy string
+
+const (
+	BackoffFixed       BackoffStrategy = "fixed"
+	BackoffExponential BackoffStrategy = "exponential"
+	BackoffJittered    BackoffStrategy = "jittered"
+)
+
+func (b *BackoffStrategy) String() string {
+	return string(*b)
+}
+
+// Set implements flag.Value so the strategy can be chosen on the command line
+func (b *BackoffStrategy) Set(value string) error {
+	switch BackoffStrategy(value) {
+	case BackoffFixed, BackoffExponential, BackoffJittered:
+		*b = BackoffStrategy(value)
+		return nil
+	}
+	return fmt.Errorf("unknown backoff strategy %q (want fixed, exponential or jittered)", value)
+}
+
+// How aborted transactions are re-executed
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    BackoffStrategy
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+var retryPolicy = RetryPolicy{
+	MaxRetries: maxRetryAttempts,
+	Backoff:    BackoffExponential,
+	BaseDelay:  50 * time.Millisecond,
+	MaxDelay:   2 * time.Second,
+}
+
+// Delay to wait after the given (1-based) failed attempt
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	var delay time.Duration
+	switch p.Backoff {
+	case BackoffFixed:
+		delay = p.BaseDelay
+	case BackoffJittered:
+		// Full jitter: uniform in [0, exponential delay]
+		ceiling := p.exponential(attempt)
+		if ceiling <= 0 {
+			return 0
+		}
+		delay = time.Duration(rand.Int63n(int64(ceiling) + 1))
+	default:
+		delay = p.exponential(attempt)
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func (p RetryPolicy) exponential(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return delay
+}
+
+// Retry cost for one transaction type (Sharded / Non-Sharded)
+type RetryStats struct {
+	Transactions  int     `json:"transactions"`
+	TotalAttempts int     `json:"total_attempts"`
+	Retried       int     `json:"retried"`
+	Aborted       int     `json:"aborted"`
+	AvgAttempts   float64 `json:"avg_attempts"`
+}
+
+// Aggregate attempts and outcomes per transaction type
+func summariseRetries(logs []TransactionLog) map[string]RetryStats {
+	stats := make(map[string]RetryStats)
+	for _, entry := range logs {
+		if entry.Attempts == 0 {
+			continue // Logged before retries were tracked, or never executed
+		}
+		s := stats[entry.Type]
+		s.Transactions++
+		s.TotalAttempts += entry.Attempts
+		if entry.Attempts > 1 {
+			s.Retried++
+		}
+		if entry.Outcome == OutcomeAborted {
+			s.Aborted++
+		}
+		s.AvgAttempts = float64(s.TotalAttempts) / float64(s.Transactions)
+		stats[entry.Type] = s
+	}
+	return stats
+}
+
+// Aborted attempts for one deadlock policy
+type AbortStats struct {
+	Transactions    int     `json:"transactions"`
+	Attempts        int     `json:"attempts"`
+	AbortedAttempts int     `json:"aborted_attempts"`
+	AbortRate       float64 `json:"abort_rate"`
+}
+
+// Aggregate the fraction of attempts that aborted per deadlock policy
+func summariseAbortRates(logs []TransactionLog) map[string]AbortStats {
+	stats := make(map[string]AbortStats)
+	for _, entry := range logs {
+		if entry.Attempts == 0 || entry.Policy == "" {
+			continue
+		}
+		s := stats[entry.Policy]
+		s.Transactions++
+		s.Attempts += entry.Attempts
+		s.AbortedAttempts += entry.Attempts
+		if entry.Outcome == OutcomeCommitted {
+			s.AbortedAttempts-- // Only the final attempt committed
+		}
+		s.AbortRate = float64(s.AbortedAttempts) / float64(s.Attempts)
+		stats[entry.Policy] = s
+	}
+	return stats
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rolling back to height N keeps the first N blocks of the main chain and
+// restores the world state to what it was when block N-1 was sealed, less
+// the commits of transactions in dropped blocks, which may predate the seal.
+// Every transaction in a dropped block, and every transaction committed to
+// the world state after that point, is reverted. Shard assignments changed since
+// the chain was N blocks long are restored, and shard and beacon chains are
+// cut back to before the first reverted transaction.
+
+// Status of a transaction undone by a rollback
+const statusReverted = "reverted"
+
+// A block moved between shards while the chain had a given height
+type shardAssignment struct {
+	ChainHeight int `json:"chain_height"`
+	Index       int `json:"index"`
+	From        int `json:"from"`
+	To          int `json:"to"`
+}
+
+var (
+	shardAssignments   []shardAssignment
+	shardAssignmentsMu sync.Mutex
+)
+
+var rollbackDepth = 1000 // Sealed blocks a rollback can undo (0 = no limit)
+
+// Remember a shard reassignment so a rollback can undo it. Caller must hold
+// BlockchainMu.
+func recordShardAssignment(index, from, to int) {
+	if from == to {
+		return
+	}
+	shardAssignmentsMu.Lock()
+	defer shardAssignmentsMu.Unlock()
+	shardAssignments = append(shardAssignments, shardAssignment{ChainHeight: len(Blockchain), Index: index, From: from, To: to})
+}
+
+// Drop the world state undo records a rollback can no longer reach: those at
+// or below the state height of the block rollbackDepth blocks behind the
+// sealed tip. Caller must hold BlockchainMu.
+func pruneRollbackState() {
+	keep := len(Blockchain) - 1 - rollbackDepth
+	if rollbackDepth <= 0 || keep < 0 {
+		return
+	}
+	worldState.PruneUndo(Blockchain[keep].StateHeight)
+}
+
+// Outcome of a rollback
+type RollbackResult struct {
+	Height          int      `json:"height"`
+	RemovedBlocks   int      `json:"removed_blocks"`
+	StateHeight     int      `json:"state_height"`
+	Reverted        []string `json:"reverted"`
+	Requeued        []string `json:"requeued"`
+	NotRequeued     []string `json:"not_requeued"` // Reverted with no transfer on record to run again
+	ShardsRestored  int      `json:"shards_restored"`
+	BeaconTruncated int      `json:"beacon_truncated"`
+}
+
+// Roll the node back to the given main-chain height. With requeue, reverted
+// transfers go back into the mempool and run again; otherwise they are
+// marked reverted. Reverted transactions whose transfer is not in a removed
+// block, the mempool or the write-ahead log are listed as not requeued.
+//
+// BlockchainMu is held until the world state is rolled back and every undone
+// transaction is marked reverted. A transaction that committed to the world
+// state but has not reached the ledger yet sees the mark when it takes
+// BlockchainMu to append itself, and drops out instead.
+func rollbackTo(height int, requeue bool) (*RollbackResult, error) {
+	var submitted map[string]Transaction
+	if requeue { // Read before locking; committed-but-unrecorded transfers are only here
+		submitted = txWAL.Submissions()
+	}
+	BlockchainMu.Lock()
+	if height < 1 || height > len(Blockchain) {
+		n := len(Blockchain)
+		BlockchainMu.Unlock()
+		return nil, fmt.Errorf("height must be between 1 and %d", n)
+	}
+	result := &RollbackResult{Height: height, Reverted: make([]string, 0), Requeued: make([]string, 0), NotRequeued: make([]string, 0)}
+	if height == len(Blockchain) {
+		BlockchainMu.Unlock()
+		return result, nil
+	}
+	result.StateHeight = Blockchain[height-1].StateHeight
+	if floor := worldState.UndoFloor(); result.StateHeight < floor {
+		BlockchainMu.Unlock()
+		return nil, fmt.Errorf("height %d is beyond the rollback depth; the world state is kept back to height %d", height, floor)
+	}
+
+	removed := Blockchain[height:]
+	result.RemovedBlocks = len(removed)
+	Blockchain = append([]Block(nil), Blockchain[:height]...)
+
+	// Undo shard moves made after the chain was this long, newest first
+	shardAssignmentsMu.Lock()
+	for len(shardAssignments) > 0 && shardAssignments[len(shardAssignments)-1].ChainHeight > height {
+		move := shardAssignments[len(shardAssignments)-1]
+		if block := findBlockByIndex(move.Index); block != nil {
+			block.ShardID = move.From
+		}
+		shardAssignments = shardAssignments[:len(shardAssignments)-1]
+		result.ShardsRestored++
+	}
+	shardAssignmentsMu.Unlock()
+
+	// Everything the dropped blocks held, plus anything committed after the
+	// new tip was sealed
+	var reverted []Transaction
+	seen := make(map[string]bool)
+	for _, block := range removed {
+		for _, tx := range block.Transactions {
+			if !seen[tx.TransactionID] {
+				seen[tx.TransactionID] = true
+				reverted = append(reverted, tx)
+			}
+		}
+	}
+	// Transactions of the dropped blocks may have committed before the new
+	// tip was sealed, so they are undone by ID as well as by height
+	undone, err := worldState.RollbackTo(result.StateHeight, func(txID string) bool {
+		return seen[strings.TrimSuffix(txID, "-credit")]
+	})
+	if err != nil { // Checked against the undo floor above, under the same lock
+		BlockchainMu.Unlock()
+		return nil, err
+	}
+	TransactionMu.Lock()
+	for _, txID := range undone {
+		txID = strings.TrimSuffix(txID, "-credit") // Receipt credits belong to their transfer
+		if txID != "" && !seen[txID] {
+			seen[txID] = true
+			tx := Transaction{TransactionID: txID}
+			if pooled := TransactionPool[txID]; pooled != nil {
+				tx = *pooled
+			} else if logged, ok := submitted[txID]; ok {
+				tx = logged
+			}
+			reverted = append(reverted, tx)
+		}
+	}
+
+	var batch []BatchTx
+	for _, tx := range reverted {
+		result.Reverted = append(result.Reverted, tx.TransactionID)
+		delete(TransactionPool, tx.TransactionID)
+		transactionStatus[tx.TransactionID] = statusReverted
+		if !requeue {
+			continue
+		}
+		if tx.Source == tx.Target {
+			result.NotRequeued = append(result.NotRequeued, tx.TransactionID)
+		} else {
+			batch = append(batch, BatchTx{
+				TransactionID: tx.TransactionID,
+				Source:        tx.Source,
+				Target:        tx.Target,
+				Data:          tx.Data,
+				IsSharded:     tx.Type == "Sharded",
+				Signer:        SignedRequest{KeyID: tx.SignerKeyID, Signature: tx.Signature},
+				Origin:        tx.TxOrigin,
+				Priority:      tx.Priority,
+			})
+		}
+	}
+	TransactionMu.Unlock()
+	BlockchainMu.Unlock()
+
+	for _, txID := range result.Reverted {
+		txWAL.Abort(txID, statusReverted, fmt.Sprintf("rolled back to height %d", height))
+	}
+
+	receiptQueue.forget(seen)
+	for _, chain := range allShardChains() {
+		chain.revert(seen)
+	}
+	result.BeaconTruncated = beaconChain.truncateInvalid()
+	distributeBlocksToShards()
+	ledgerStore.Changed()
+
+	if len(batch) > 0 {
+		result.Requeued = submitBatch(batch, defaultExecOptions())
+	}
+	log.Printf("⏪ Rolled back to height %d: %d blocks removed, %d transactions reverted, %d requeued, %d not requeued",
+		height, result.RemovedBlocks, len(result.Reverted), len(result.Requeued), len(result.NotRequeued))
+	return result, nil
+}
+
+// Drop receipts of reverted transactions
+func (q *ReceiptQueue) forget(txIDs map[string]bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, receipt := range q.receipts {
+		if txIDs[receipt.TxID] {
+			delete(q.receipts, id)
+			delete(q.done, id)
+		}
+	}
+}
+
+// Cut the shard chain back to before its first block holding a reverted
+// transaction and drop reverted transactions from its queue. The surviving
+// transactions of the cut blocks go back to the front of the queue, and the
+// producer is held off until they are there.
+func (c *ShardChain) revert(reverted map[string]bool) {
+	c.mu.Lock()
+	queue := c.queue[:0]
+	for _, tx := range c.queue {
+		if !reverted[tx.TransactionID] {
+			queue = append(queue, tx)
+		}
+	}
+	c.queue = queue
+	cut := -1
+	for i := range c.Blocks {
+		for _, txID := range c.Blocks[i].TxIDs {
+			if reverted[txID] {
+				cut = i
+				break
+			}
+		}
+		if cut >= 0 {
+			break
+		}
+	}
+	if cut < 0 {
+		c.mu.Unlock()
+		return
+	}
+	var survivors []string
+	for _, block := range c.Blocks[cut:] {
+		for _, txID := range block.TxIDs {
+			if !reverted[txID] {
+				survivors = append(survivors, txID)
+			}
+		}
+	}
+	c.Blocks = c.Blocks[:cut]
+	c.paused = true
+	c.mu.Unlock()
+
+	var requeued []Transaction
+	for _, txID := range survivors {
+		if tx, ok := findLedgerTransaction(txID); ok {
+			requeued = append(requeued, tx)
+		}
+	}
+	c.mu.Lock()
+	c.queue = append(requeued, c.queue...)
+	c.paused = false
+	c.mu.Unlock()
+	c.signal()
+}
+
+// Transaction as stored in the main chain
+func findLedgerTransaction(txID string) (Transaction, bool) {
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	for _, block := range Blockchain {
+		for _, tx := range block.Transactions {
+			if tx.TransactionID == txID {
+				return tx, true
+			}
+		}
+	}
+	return Transaction{}, false
+}
+
+// Drop beacon blocks from the first one committing a shard head that no
+// longer exists. Returns the number of blocks dropped.
+func (b *BeaconChain) truncateInvalid() int {
+	heads := make(map[int][]string) // Shard -> block hashes by height-1
+	for _, chain := range allShardChains() {
+		chain.mu.Lock()
+		for _, block := range chain.Blocks {
+			heads[chain.ShardID] = append(heads[chain.ShardID], block.Hash)
+		}
+		chain.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, block := range b.Blocks {
+		for shardID, head := range block.ShardHeads {
+			hashes := heads[shardID]
+			if head.Height > len(hashes) || hashes[head.Height-1] != head.Hash {
+				dropped := len(b.Blocks) - i
+				b.Blocks = b.Blocks[:i]
+				return dropped
+			}
+		}
+	}
+	return 0
+}
+
+// API to roll the node back to ?height=N. ?requeue=true sends reverted
+// transactions back to the mempool instead of marking them reverted.
+func rollbackHandler(c *gin.Context) {
+	height, err := strconv.Atoi(c.Query("height"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing or invalid height"})
+		return
+	}
+	requeue := c.Query("requeue") == "true"
+
+	result, err := rollbackTo(height, requeue)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Commit a one-key write and return its height
+func commitKey(t *testing.T, txID, key, value string) int {
+	t.Helper()
+	height, err := worldState.Commit(CommitMeta{TxID: txID}, nil, []WriteEntry{{Key: key, Value: value}})
+	if err != nil {
+		t.Fatalf("commit %s: %v", txID, err)
+	}
+	return height
+}
+
+func TestWorldStateRollbackNeverReusesHeights(t *testing.T) {
+	useFreshWorldState(t)
+	commitKey(t, "tx-1", "acct-1", "1")
+	reverted := commitKey(t, "tx-2", "acct-1", "2")
+
+	undone, err := worldState.RollbackTo(1, nil)
+	if err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+	if len(undone) != 1 || undone[0] != "tx-2" {
+		t.Fatalf("undone = %v, want [tx-2]", undone)
+	}
+	if got := worldState.Get("acct-1"); got.Value != "1" || got.Version != 1 {
+		t.Fatalf("acct-1 = %+v after rollback, want the value at height 1", got)
+	}
+
+	// A reader that saw the undone write must not validate against a later one
+	if height := commitKey(t, "tx-3", "acct-1", "3"); height <= reverted {
+		t.Fatalf("commit after rollback got height %d, reusing undone height %d", height, reverted)
+	}
+	_, err = worldState.Commit(CommitMeta{TxID: "tx-4"}, []ReadEntry{{Key: "acct-1", Version: reverted}}, []WriteEntry{{Key: "acct-1", Value: "4"}})
+	if err == nil {
+		t.Fatal("a read of the undone version validated")
+	}
+}
+
+func TestWorldStatePruneUndo(t *testing.T) {
+	useFreshWorldState(t)
+	for _, txID := range []string{"tx-1", "tx-2", "tx-3", "tx-4", "tx-5"} {
+		commitKey(t, txID, "acct-1", txID)
+	}
+	worldState.PruneUndo(3)
+	if len(worldState.undo) != 2 {
+		t.Fatalf("%d undo records kept, want 2", len(worldState.undo))
+	}
+	if _, err := worldState.RollbackTo(2, nil); err == nil {
+		t.Fatal("rolled back below the pruned height")
+	}
+	if _, err := worldState.RollbackTo(3, nil); err != nil {
+		t.Fatalf("RollbackTo(3): %v", err)
+	}
+	if got := worldState.Get("acct-1"); got.Value != "tx-3" {
+		t.Errorf("acct-1 = %q, want tx-3", got.Value)
+	}
+}
+
+// Set up a chain of sealed blocks, one committed transfer each, on a fresh
+// world state
+func setupRollbackChain(t *testing.T, blocks int) {
+	t.Helper()
+	walPath := txWAL.path
+	txWAL.path = ""
+	chain, state := Blockchain, worldState
+	t.Cleanup(func() {
+		txWAL.path = walPath
+		Blockchain, worldState = chain, state
+		distributeBlocksToShards()
+	})
+
+	worldState = NewWorldState()
+	initShards()
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	Blockchain = []Block{{Index: 0, Sealed: true}}
+	for i := 1; i < blocks; i++ {
+		tx := Transaction{TransactionID: fmt.Sprintf("block-tx-%d", i), Source: 1, Target: 2}
+		tx.ReadSet, tx.WriteSet = executeTransfer(worldState.Get, tx.Source, tx.Target, defaultTransferAmount)
+		var err error
+		if tx.Version, err = worldState.Commit(CommitMeta{TxID: tx.TransactionID}, tx.ReadSet, tx.WriteSet); err != nil {
+			t.Fatalf("commit %s: %v", tx.TransactionID, err)
+		}
+		Blockchain = append(Blockchain, Block{Index: i, Transactions: []Transaction{tx}, Sealed: true, StateHeight: worldState.height})
+	}
+}
+
+func TestRollbackRevertsInFlightCommits(t *testing.T) {
+	setupRollbackChain(t, 3)
+	// Committed to the world state but not yet appended to the ledger
+	commitKey(t, "in-flight", "acct-7", "1")
+	TransactionMu.Lock()
+	transactionStatus["in-flight"] = "pending"
+	TransactionMu.Unlock()
+
+	result, err := rollbackTo(2, false)
+	if err != nil {
+		t.Fatalf("rollbackTo: %v", err)
+	}
+	if len(Blockchain) != 2 || result.RemovedBlocks != 1 {
+		t.Fatalf("chain has %d blocks after removing %d, want 2 after removing 1", len(Blockchain), result.RemovedBlocks)
+	}
+	TransactionMu.Lock()
+	defer TransactionMu.Unlock()
+	for _, txID := range []string{"block-tx-2", "in-flight"} {
+		if transactionStatus[txID] != statusReverted {
+			t.Errorf("%s is %q, want %q", txID, transactionStatus[txID], statusReverted)
+		}
+	}
+	if got := worldState.Get("acct-7"); got.Version != 0 {
+		t.Errorf("in-flight write survived the rollback: %+v", got)
+	}
+}
+
+func TestRollbackBeyondDepth(t *testing.T) {
+	depth := rollbackDepth
+	rollbackDepth = 1
+	t.Cleanup(func() { rollbackDepth = depth })
+	setupRollbackChain(t, 5)
+	BlockchainMu.Lock()
+	pruneRollbackState()
+	BlockchainMu.Unlock()
+
+	if _, err := rollbackTo(2, false); err == nil {
+		t.Fatal("rolled back past the rollback depth")
+	}
+	if len(Blockchain) != 5 {
+		t.Fatalf("a refused rollback cut the chain to %d blocks", len(Blockchain))
+	}
+	if _, err := rollbackTo(4, false); err != nil {
+		t.Fatalf("rollbackTo(4): %v", err)
+	}
+}
+
+func TestRollbackUndoesDroppedCommitsBeforeTheSeal(t *testing.T) {
+	setupRollbackChain(t, 1)
+	commitKey(t, "kept-1", "acct-1", "kept-1")
+	early := Transaction{TransactionID: "dropped", WriteSet: []WriteEntry{{Key: "acct-1", Value: "dropped"}, {Key: "acct-2", Value: "dropped"}}}
+	var err error
+	if early.Version, err = worldState.Commit(CommitMeta{TxID: early.TransactionID}, nil, early.WriteSet); err != nil {
+		t.Fatal(err)
+	}
+	kept := Transaction{TransactionID: "kept-2", WriteSet: []WriteEntry{{Key: "acct-1", Value: "kept-2"}}}
+	if kept.Version, err = worldState.Commit(CommitMeta{TxID: kept.TransactionID}, nil, kept.WriteSet); err != nil {
+		t.Fatal(err)
+	}
+	// The dropped transaction committed before block 1 was sealed but was
+	// recorded in block 2, as an oversized batch would be
+	BlockchainMu.Lock()
+	Blockchain = append(Blockchain,
+		Block{Index: 1, Transactions: []Transaction{kept}, Sealed: true, StateHeight: worldState.height},
+		Block{Index: 2, Transactions: []Transaction{early}, Sealed: true, StateHeight: worldState.height})
+	BlockchainMu.Unlock()
+
+	if _, err := rollbackTo(2, false); err != nil {
+		t.Fatalf("rollbackTo: %v", err)
+	}
+	if got := worldState.Get("acct-2"); got.Version != 0 {
+		t.Errorf("acct-2 = %+v, the dropped write survived", got)
+	}
+	if got := worldState.Get("acct-1"); got.Value != "kept-2" || got.Version != kept.Version {
+		t.Errorf("acct-1 = %+v, want the kept write at version %d", got, kept.Version)
+	}
+	if len(worldState.undo) != 2 {
+		t.Errorf("%d undo records kept, want 2", len(worldState.undo))
+	}
+	// The redone commit can still be undone to what it overwrote after the rollback
+	if _, err := worldState.RollbackTo(1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := worldState.Get("acct-1"); got.Value != "kept-1" {
+		t.Errorf("acct-1 = %q after undoing kept-2, want kept-1", got.Value)
+	}
+}
+
+func TestRollbackRequeuesLoggedTransfers(t *testing.T) {
+	setupRollbackChain(t, 2)
+	wal := txWAL
+	txWAL = &WAL{path: filepath.Join(t.TempDir(), "wal.log")}
+	t.Cleanup(func() {
+		txWAL.Reset()
+		txWAL = wal
+	})
+	// Batch transfers leave the mempool once committed; the log still has them.
+	// Source 99 does not exist, so the requeued runs fail without executing.
+	txWAL.Submit(Transaction{TransactionID: "logged", Source: 99, Target: 2})
+	commitKey(t, "logged", "acct-7", "1")
+	commitKey(t, "unknown", "acct-8", "1")
+
+	result, err := rollbackTo(1, true)
+	if err != nil {
+		t.Fatalf("rollbackTo: %v", err)
+	}
+	if got := fmt.Sprint(result.Requeued); got != "[block-tx-1 logged]" {
+		t.Errorf("requeued %s, want [block-tx-1 logged]", got)
+	}
+	if got := fmt.Sprint(result.NotRequeued); got != "[unknown]" {
+		t.Errorf("not requeued %s, want [unknown]", got)
+	}
+}
+
+func TestRemoveLastBlockRevertsIt(t *testing.T) {
+	setupRollbackChain(t, 3)
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.DELETE("/removeLastBlock", removeLastBlock)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/removeLastBlock", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /removeLastBlock = %d: %s", w.Code, w.Body)
+	}
+	if len(Blockchain) != 2 {
+		t.Fatalf("chain has %d blocks, want 2", len(Blockchain))
+	}
+	TransactionMu.Lock()
+	status := transactionStatus["block-tx-2"]
+	TransactionMu.Unlock()
+	if status != statusReverted {
+		t.Errorf("block-tx-2 is %q, want %q", status, statusReverted)
+	}
+	if got := worldState.Get(accountKey(1)); got.Value != "-1" {
+		t.Errorf("%s = %q after removing the second transfer, want -1", accountKey(1), got.Value)
+	}
+}
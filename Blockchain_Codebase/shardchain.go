@@ -0,0 +1,433 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every shard keeps its own hash-linked chain of blocks with its own height.
+// A shard block commits to the shard's transactions by their Merkle leaf
+// hashes; the transactions themselves live in the main ledger. Each shard
+// produces its blocks on its own: committed transactions are queued on the
+// shard's chain, and the shard's producer adds them to its open block and
+// seals it on the count and age limits, independently of the main ledger
+// and of other shards. The beacon chain periodically commits the head of
+// every shard chain, tying the independent chains together.
+
+// Block of a shard chain
+type ShardBlock struct {
+	ShardID      int       `json:"shard_id"`
+	Height       int       `json:"height"`
+	Timestamp    string    `json:"timestamp"`
+	HLC          HLC       `json:"hlc"`
+	TxIDs        []string  `json:"tx_ids"`
+	TxHashes     []string  `json:"tx_hashes"`
+	MerkleRoot   string    `json:"merkle_root"`
+	PreviousHash string    `json:"previous_hash"`
+	Hash         string    `json:"hash"`
+	Sealed       bool      `json:"sealed"`
+	openedAt     time.Time // For age-based sealing
+}
+
+// Recompute the Merkle root and hash. TxHashes are leaf hashes, already
+// prefixed, so the root is built exactly like a main ledger block's.
+func (b *ShardBlock) seal() {
+	leaves := make([][]byte, len(b.TxHashes))
+	for i, h := range b.TxHashes {
+		leaves[i], _ = hex.DecodeString(h)
+	}
+	b.MerkleRoot = merkleRootOfLeaves(leaves)
+	b.Hash = shardBlockHash(b)
+}
+
+func shardBlockHash(b *ShardBlock) string {
+	record := fmt.Sprintf("%d%d%s%s%s%s", b.ShardID, b.Height, b.Timestamp, b.HLC, b.MerkleRoot, b.PreviousHash)
+	hash := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hash[:])
+}
+
+// Chain of one shard
+type ShardChain struct {
+	mu      sync.Mutex
+	ShardID int
+	Blocks  []ShardBlock
+	queue   []Transaction // Committed, waiting for the shard's producer
+	paused  bool          // A rollback is rebuilding the chain
+	wake    chan struct{}
+}
+
+// New shard chain with its producer running
+func newShardChain(shardID int) *ShardChain {
+	chain := &ShardChain{ShardID: shardID, wake: make(chan struct{}, 1)}
+	go chain.run()
+	return chain
+}
+
+var (
+	shardChains   = make(map[int]*ShardChain)
+	shardChainsMu sync.Mutex
+)
+
+// Chain of a shard, created on first use so shards added at runtime get one
+func shardChain(shardID int) *ShardChain {
+	shardChainsMu.Lock()
+	defer shardChainsMu.Unlock()
+	chain, ok := shardChains[shardID]
+	if !ok {
+		chain = newShardChain(shardID)
+		shardChains[shardID] = chain
+	}
+	return chain
+}
+
+// Chain of a shard, if it has one
+func lookupShardChain(shardID int) (*ShardChain, bool) {
+	shardChainsMu.Lock()
+	defer shardChainsMu.Unlock()
+	chain, ok := shardChains[shardID]
+	return chain, ok
+}
+
+// Every shard chain, ordered by shard ID
+func allShardChains() []*ShardChain {
+	shardChainsMu.Lock()
+	defer shardChainsMu.Unlock()
+	chains := make([]*ShardChain, 0, len(shardChains))
+	for _, chain := range shardChains {
+		chains = append(chains, chain)
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].ShardID < chains[j].ShardID })
+	return chains
+}
+
+// Hand a committed transaction to the shard's producer
+func (c *ShardChain) Submit(tx Transaction) {
+	c.mu.Lock()
+	c.queue = append(c.queue, tx)
+	c.mu.Unlock()
+	c.signal()
+}
+
+// Wake the producer
+func (c *ShardChain) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default: // Already signalled
+	}
+}
+
+// Produce the shard's blocks: take in queued transactions as they arrive
+// and seal the open block once it is older than the producer's MaxAge
+func (c *ShardChain) run() {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.wake:
+			c.produce()
+		case <-ticker.C:
+			if maxAge := blockProducer.MaxAge; maxAge > 0 {
+				c.sealAged(maxAge)
+			}
+		}
+	}
+}
+
+// Add every queued transaction to the chain
+func (c *ShardChain) produce() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return // Resumed, and woken again, once the rollback is done
+	}
+	for _, tx := range c.queue {
+		c.record(tx)
+	}
+	c.queue = nil
+}
+
+// Queued transactions not yet in a block
+func (c *ShardChain) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// Add a committed transaction to the shard's open block, sealing it once it
+// holds as many transactions as a main-chain block may. Caller must hold
+// c.mu.
+func (c *ShardChain) record(tx Transaction) {
+	if len(c.Blocks) == 0 || c.Blocks[len(c.Blocks)-1].Sealed {
+		previousHash := "0"
+		if len(c.Blocks) > 0 {
+			previousHash = c.Blocks[len(c.Blocks)-1].Hash
+		}
+		c.Blocks = append(c.Blocks, ShardBlock{
+			ShardID:      c.ShardID,
+			Height:       len(c.Blocks) + 1,
+			Timestamp:    time.Now().Format(time.RFC3339),
+			HLC:          hybridClock.Now(),
+			TxIDs:        make([]string, 0),
+			TxHashes:     make([]string, 0),
+			PreviousHash: previousHash,
+			openedAt:     time.Now(),
+		})
+	}
+	open := &c.Blocks[len(c.Blocks)-1]
+	open.TxIDs = append(open.TxIDs, tx.TransactionID)
+	open.TxHashes = append(open.TxHashes, hex.EncodeToString(txLeafHash(tx)))
+	open.seal()
+
+	if limit := blockProducer.MaxTransactions; limit > 0 && len(open.TxIDs) >= limit {
+		c.sealOpen("transaction limit")
+	}
+}
+
+// Caller must hold c.mu
+func (c *ShardChain) sealOpen(reason string) {
+	if len(c.Blocks) == 0 || c.Blocks[len(c.Blocks)-1].Sealed {
+		return
+	}
+	open := &c.Blocks[len(c.Blocks)-1]
+	open.seal()
+	open.Sealed = true
+	log.Printf("📦 Shard %d sealed block at height %d with %d transactions (%s)", c.ShardID, open.Height, len(open.TxIDs), reason)
+}
+
+// Seal the open block if it has been open longer than maxAge
+func (c *ShardChain) sealAged(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.Blocks); n > 0 && !c.Blocks[n-1].Sealed && time.Since(c.Blocks[n-1].openedAt) >= maxAge {
+		c.sealOpen("time limit")
+	}
+}
+
+// Height and hash of the last sealed block; height 0 for an empty chain
+func (c *ShardChain) Head() ShardHead {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.Blocks) - 1; i >= 0; i-- {
+		if c.Blocks[i].Sealed {
+			return ShardHead{Height: c.Blocks[i].Height, Hash: c.Blocks[i].Hash}
+		}
+	}
+	return ShardHead{}
+}
+
+// Recompute hashes and links of the shard chain
+func (c *ShardChain) verify() []ShardIssue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var issues []ShardIssue
+	for i := range c.Blocks {
+		b := c.Blocks[i]
+		expectedPrev := "0"
+		if i > 0 {
+			expectedPrev = c.Blocks[i-1].Hash
+		}
+		if b.PreviousHash != expectedPrev || b.Height != i+1 {
+			issues = append(issues, ShardIssue{ShardID: c.ShardID, Block: b.Height, Problem: "shard chain link broken"})
+		}
+		stored := b.Hash
+		b.seal()
+		if b.Hash != stored {
+			issues = append(issues, ShardIssue{ShardID: c.ShardID, Block: b.Height, Problem: "shard block hash does not match contents"})
+		}
+	}
+	return issues
+}
+
+// Head of a shard chain as committed by the beacon chain
+type ShardHead struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// Block of the beacon chain
+type BeaconBlock struct {
+	Height       int               `json:"height"`
+	Timestamp    string            `json:"timestamp"`
+	HLC          HLC               `json:"hlc"`
+	ShardHeads   map[int]ShardHead `json:"shard_heads"`
+	PreviousHash string            `json:"previous_hash"`
+	Hash         string            `json:"hash"`
+}
+
+func beaconBlockHash(b *BeaconBlock) string {
+	heads, _ := json.Marshal(b.ShardHeads) // Map keys are encoded in sorted order
+	record := fmt.Sprintf("%d%s%s%s%s", b.Height, b.Timestamp, b.HLC, heads, b.PreviousHash)
+	hash := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(hash[:])
+}
+
+type BeaconChain struct {
+	mu       sync.Mutex
+	Blocks   []BeaconBlock
+	Interval time.Duration
+}
+
+var beaconChain = &BeaconChain{Interval: 5 * time.Second}
+
+// Commit the current shard heads, unless none moved since the last beacon
+// block. Returns the new block, or nil if nothing was committed.
+func (b *BeaconChain) Commit() *BeaconBlock {
+	heads := make(map[int]ShardHead)
+	for _, chain := range allShardChains() {
+		if head := chain.Head(); head.Height > 0 {
+			heads[chain.ShardID] = head
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	previousHash := "0"
+	if n := len(b.Blocks); n > 0 {
+		last := b.Blocks[n-1]
+		if sameHeads(last.ShardHeads, heads) {
+			return nil
+		}
+		previousHash = last.Hash
+	} else if len(heads) == 0 {
+		return nil
+	}
+
+	block := BeaconBlock{
+		Height:       len(b.Blocks) + 1,
+		Timestamp:    time.Now().Format(time.RFC3339),
+		HLC:          hybridClock.Now(),
+		ShardHeads:   heads,
+		PreviousHash: previousHash,
+	}
+	block.Hash = beaconBlockHash(&block)
+	b.Blocks = append(b.Blocks, block)
+	log.Printf("🛰️ Beacon block %d commits %d shard heads", block.Height, len(heads))
+	return &b.Blocks[len(b.Blocks)-1]
+}
+
+func sameHeads(a, b map[int]ShardHead) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for shardID, head := range a {
+		if b[shardID] != head {
+			return false
+		}
+	}
+	return true
+}
+
+// Commit shard heads every Interval
+func (b *BeaconChain) Run() {
+	if b.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.Interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		b.Commit()
+	}
+}
+
+// Check the beacon chain's links and that every committed head exists in
+// its shard chain
+func (b *BeaconChain) verify() []ShardIssue {
+	b.mu.Lock()
+	blocks := append([]BeaconBlock(nil), b.Blocks...)
+	b.mu.Unlock()
+
+	var issues []ShardIssue
+	for i := range blocks {
+		block := blocks[i]
+		expectedPrev := "0"
+		if i > 0 {
+			expectedPrev = blocks[i-1].Hash
+		}
+		if block.PreviousHash != expectedPrev || beaconBlockHash(&block) != block.Hash {
+			issues = append(issues, ShardIssue{ShardID: -1, Block: block.Height, Problem: "beacon chain link or hash broken"})
+		}
+		for shardID, head := range block.ShardHeads {
+			found := false
+			if chain, ok := lookupShardChain(shardID); ok {
+				chain.mu.Lock()
+				found = head.Height >= 1 && head.Height <= len(chain.Blocks) && chain.Blocks[head.Height-1].Hash == head.Hash
+				chain.mu.Unlock()
+			}
+			if !found {
+				issues = append(issues, ShardIssue{ShardID: shardID, Block: head.Height, Problem: fmt.Sprintf("beacon block %d commits a head not in the shard chain", block.Height)})
+			}
+		}
+	}
+	return issues
+}
+
+// Whether the shard is one of the fixed shards or has blocks assigned to it
+func shardExists(shardID int) bool {
+	if shardID >= 0 && shardID < NumShards {
+		return true
+	}
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	for _, block := range Blockchain {
+		if block.ShardID == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// API to inspect one shard's chain
+func getShardChain(c *gin.Context) {
+	shardID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid shard ID"})
+		return
+	}
+	chain, ok := lookupShardChain(shardID)
+	if !ok && !shardExists(shardID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Shard %d not found", shardID)})
+		return
+	}
+	var (
+		head    ShardHead
+		blocks  = make([]ShardBlock, 0)
+		pending int
+	)
+	if ok { // A shard that has produced nothing yet has no chain
+		head = chain.Head()
+		pending = chain.Pending()
+		chain.mu.Lock()
+		blocks = append(blocks, chain.Blocks...)
+		chain.mu.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shard_id": shardID,
+		"height":   head.Height,
+		"head":     head.Hash,
+		"blocks":   blocks,
+		"pending":  pending,
+	})
+}
+
+// API to inspect the beacon chain
+func getBeaconChain(c *gin.Context) {
+	beaconChain.mu.Lock()
+	blocks := append([]BeaconBlock(nil), beaconChain.Blocks...)
+	beaconChain.mu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"height": len(blocks),
+		"blocks": blocks,
+	})
+}
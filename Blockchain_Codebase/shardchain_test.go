@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShardProducesItsOwnBlocks(t *testing.T) {
+	chain := newShardChain(1000)
+	limit := blockProducer.MaxTransactions
+	for i := 0; i < 2*limit+1; i++ {
+		chain.Submit(Transaction{TransactionID: fmt.Sprintf("tx-%d", i), Source: i})
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for chain.Pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	chain.mu.Lock()
+	blocks := append([]ShardBlock(nil), chain.Blocks...)
+	chain.mu.Unlock()
+	if len(blocks) != 3 {
+		t.Fatalf("shard produced %d blocks, want 3", len(blocks))
+	}
+	if !blocks[0].Sealed || !blocks[1].Sealed || blocks[2].Sealed {
+		t.Errorf("full blocks must be sealed and the last left open")
+	}
+	if head := chain.Head(); head.Height != 2 || head.Hash != blocks[1].Hash {
+		t.Errorf("head = %+v, want the second block", head)
+	}
+	if issues := chain.verify(); len(issues) > 0 {
+		t.Errorf("shard chain does not verify: %+v", issues)
+	}
+}
+
+func TestGetShardChainUnknownShard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/shards/:id/chain", getShardChain)
+
+	for path, want := range map[string]int{
+		"/shards/0/chain":     http.StatusOK,
+		"/shards/4242/chain":  http.StatusNotFound,
+		"/shards/shard/chain": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, w.Code, want)
+		}
+	}
+	if _, ok := lookupShardChain(4242); ok {
+		t.Error("looking up an unknown shard created its chain")
+	}
+}
@@ -7,9 +7,11 @@ import (
 
 // Shard structure to hold blocks
 type Shard struct {
-	ID     int
-	Blocks []*Block
-	mu     sync.Mutex
+	ID       int
+	Blocks   []*Block
+	mu       sync.Mutex
+	prepared map[string]preparedTx // 2PC transactions voted yes, awaiting a decision
+	Chain    *ShardChain           // The shard's own chain, produced by the shard
 }
 
 // Initialize shards
@@ -18,7 +20,7 @@ var shards []Shard // Use exported NumShards
 func initShards() {
 	shards = make([]Shard, NumShards) 
 	for i := 0; i < NumShards; i++ {
-		shards[i] = Shard{ID: i}
+		shards[i] = Shard{ID: i, Chain: shardChain(i)}
 	}
 }
 
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ed25519 transaction signing. Each organisation has a signing key, looked
+// up first in the fablo crypto-config layout and otherwise generated once
+// into keyDir. Clients sign the canonical payload of a transaction, which
+// covers the chain ID and a nonce, and send the key ID and signature with
+// it. A key may use each nonce once, so a signed request cannot be replayed.
+// An organisation may only move funds out of blocks on its own shard.
+
+// Organisation that may sign transactions. Containers whose ID starts with
+// Prefix are placed on Shard.
+type Org struct {
+	Name   string `json:"name" yaml:"name"`
+	Domain string `json:"domain" yaml:"domain"`
+	Prefix string `json:"prefix" yaml:"prefix"`
+	Shard  int    `json:"shard" yaml:"shard"`
+}
+
+var orgs = defaultGenesis().Orgs // Replaced by the genesis organisations
+
+var (
+	cryptoConfigDir   = "../fablo-target/fabric-config/crypto-config"
+	keyDir            = "keys"
+	requireSignatures bool
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrBadSignature     = errors.New("signature does not match transaction")
+	ErrSignatureMissing = errors.New("transaction must be signed")
+	ErrForeignShard     = errors.New("signing organisation does not own the source block's shard")
+	ErrUnsignable       = errors.New("endpoint cannot take signed transactions and signatures are required")
+	ErrNonceMissing     = errors.New("signed transaction must carry a nonce")
+	ErrNonceReused      = errors.New("nonce was already used by this signing key")
+)
+
+// Public half of a registered key
+type SigningKey struct {
+	ID        string `json:"key_id"`
+	Org       string `json:"org"`
+	PublicKey string `json:"public_key"` // Base64
+	Source    string `json:"source"`     // File the key was loaded from
+	private   ed25519.PrivateKey
+}
+
+// Keys by ID, plus the key each organisation signs with and the nonces each
+// key has signed with
+type KeyRegistry struct {
+	mu     sync.RWMutex
+	keys   map[string]*SigningKey
+	byOrg  map[string]*SigningKey
+	nonces map[string]map[string]bool
+}
+
+var keyRegistry = &KeyRegistry{keys: make(map[string]*SigningKey), byOrg: make(map[string]*SigningKey)}
+
+// Short, stable ID of a public key
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Load or generate the signing key of every organisation
+func (r *KeyRegistry) Load() error {
+	for _, org := range orgs {
+		priv, source, err := loadFabloKey(org)
+		if err != nil {
+			return err
+		}
+		if priv == nil {
+			if priv, source, err = loadOrGenerateLocalKey(org); err != nil {
+				return err
+			}
+		}
+		pub := priv.Public().(ed25519.PublicKey)
+		key := &SigningKey{
+			ID:        keyID(pub),
+			Org:       org.Name,
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+			Source:    source,
+			private:   priv,
+		}
+		r.mu.Lock()
+		r.keys[key.ID] = key
+		r.byOrg[org.Name] = key
+		r.mu.Unlock()
+		log.Printf("🔑 %s signs with key %s (%s)", org.Name, key.ID, source)
+	}
+	return nil
+}
+
+// First Ed25519 key in the organisation's admin keystore. Fabric's own keys
+// are ECDSA; those are skipped.
+func loadFabloKey(org Org) (ed25519.PrivateKey, string, error) {
+	pattern := filepath.Join(cryptoConfigDir, "peerOrganizations", org.Domain, "users", "*", "msp", "keystore", "*")
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		priv, err := readPrivateKey(path)
+		if err == nil {
+			return priv, path, nil
+		}
+	}
+	return nil, "", nil
+}
+
+// Key previously generated into keyDir, or a fresh one written there
+func loadOrGenerateLocalKey(org Org) (ed25519.PrivateKey, string, error) {
+	path := filepath.Join(keyDir, org.Domain, "priv_sk")
+	if priv, err := readPrivateKey(path); err == nil {
+		return priv, path, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, "", err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return nil, "", err
+	}
+	log.Printf("🔑 Generated signing key for %s at %s", org.Name, path)
+	return priv, path, nil
+}
+
+// Read a PKCS#8 PEM file holding an Ed25519 key
+func readPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 key", key)
+	}
+	return priv, nil
+}
+
+// Canonical content of a transfer, hashed into its transaction ID
+func transferPayload(source, target int, data string) []byte {
+	payload, _ := json.Marshal(struct {
+		Source int    `json:"source"`
+		Target int    `json:"target"`
+		Data   string `json:"data"`
+	}{source, target, data})
+	return payload
+}
+
+// Bytes a client signs for a transfer. The chain ID and nonce tie the
+// signature to one submission on this chain.
+func signingPayload(source, target int, data, nonce string) []byte {
+	payload, _ := json.Marshal(struct {
+		ChainID string `json:"chain_id"`
+		Source  int    `json:"source"`
+		Target  int    `json:"target"`
+		Data    string `json:"data"`
+		Nonce   string `json:"nonce"`
+	}{genesisConfig.ChainID, source, target, data, nonce})
+	return payload
+}
+
+// Sign a payload with an organisation's key
+func (r *KeyRegistry) Sign(org string, payload []byte) (string, string, error) {
+	r.mu.RLock()
+	key := r.byOrg[org]
+	r.mu.RUnlock()
+	if key == nil {
+		return "", "", fmt.Errorf("no signing key for organisation %q", org)
+	}
+	return key.ID, base64.StdEncoding.EncodeToString(ed25519.Sign(key.private, payload)), nil
+}
+
+// Check a base64 signature over payload against a registered key. Returns
+// the organisation the key belongs to.
+func (r *KeyRegistry) Verify(id, signature string, payload []byte) (string, error) {
+	r.mu.RLock()
+	key := r.keys[id]
+	r.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	if !ed25519.Verify(key.private.Public().(ed25519.PublicKey), payload, sig) {
+		return "", ErrBadSignature
+	}
+	return key.Org, nil
+}
+
+// Mark a nonce used by a key. Fails if the key used it before.
+func (r *KeyRegistry) useNonce(id, nonce string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.nonces == nil {
+		r.nonces = make(map[string]map[string]bool)
+	}
+	if r.nonces[id] == nil {
+		r.nonces[id] = make(map[string]bool)
+	}
+	if r.nonces[id][nonce] {
+		return fmt.Errorf("%w: %q", ErrNonceReused, nonce)
+	}
+	r.nonces[id][nonce] = true
+	return nil
+}
+
+// Mark the nonces of the signed transactions in blocks used, so requests
+// committed before a restart cannot be replayed after it
+func (r *KeyRegistry) rememberNonces(blocks []Block) {
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx.SignerKeyID != "" && tx.Nonce != "" {
+				r.useNonce(tx.SignerKeyID, tx.Nonce)
+			}
+		}
+	}
+}
+
+// Whether the organisation's shard owns the block's keys
+func orgOwnsBlock(name string, block int) bool {
+	for _, org := range orgs {
+		if org.Name == name {
+			return org.Shard == participantForBlock(block)
+		}
+	}
+	return false
+}
+
+// Signature fields accepted on transaction requests
+type SignedRequest struct {
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// Verify a request's signature over the transfer and nonce, that the
+// signer's organisation owns the source block and that the key has not
+// used the nonce before. The nonce is used up once the request passes.
+// Unsigned requests pass unless signatures are required. Must not be called
+// while holding BlockchainMu.
+func (s SignedRequest) verify(source, target int, data, nonce string) error {
+	if s.KeyID == "" && s.Signature == "" {
+		if requireSignatures {
+			return ErrSignatureMissing
+		}
+		return nil
+	}
+	if nonce == "" {
+		return ErrNonceMissing
+	}
+	org, err := keyRegistry.Verify(s.KeyID, s.Signature, signingPayload(source, target, data, nonce))
+	if err != nil {
+		return err
+	}
+	if !orgOwnsBlock(org, source) {
+		return fmt.Errorf("%w: %s, block %d", ErrForeignShard, org, source)
+	}
+	return keyRegistry.useNonce(s.KeyID, nonce)
+}
+
+// Reject a request to an endpoint whose transfers are chosen by the server
+// or carry no transfer, so no client signature can cover them, while
+// signatures are required. Returns true if the request was rejected.
+func rejectUnsignable(c *gin.Context) bool {
+	if !requireSignatures {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": ErrUnsignable.Error()})
+	return true
+}
+
+// API listing the registered public keys
+func getSigningKeys(c *gin.Context) {
+	keyRegistry.mu.RLock()
+	keys := make([]SigningKey, 0, len(keyRegistry.keys))
+	for _, key := range keyRegistry.keys {
+		keys = append(keys, *key)
+	}
+	keyRegistry.mu.RUnlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Org < keys[j].Org })
+	c.JSON(http.StatusOK, gin.H{"keys": keys, "require_signatures": requireSignatures})
+}
+
+// `sign <org> <source> <target> <data> <nonce>`: print the key_id,
+// signature and nonce fields for a transaction request. Returns the process
+// exit code.
+func runSignCommand(args []string) int {
+	if len(args) != 5 {
+		fmt.Fprintln(os.Stderr, "usage: sign <org> <source> <target> <data> <nonce>")
+		return 2
+	}
+	source, err1 := strconv.Atoi(args[1])
+	target, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		fmt.Fprintln(os.Stderr, "❌ source and target must be block indices")
+		return 2
+	}
+	if err := keyRegistry.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	id, signature, err := keyRegistry.Sign(args[0], signingPayload(source, target, args[3], args[4]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(struct {
+		SignedRequest
+		Nonce string `json:"nonce"`
+	}{SignedRequest{KeyID: id, Signature: signature}, args[4]}, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyChecksSignerOwnsSourceShard(t *testing.T) {
+	cryptoConfigDir, keyDir = t.TempDir(), t.TempDir()
+	keyRegistry = &KeyRegistry{keys: make(map[string]*SigningKey), byOrg: make(map[string]*SigningKey)}
+	if err := keyRegistry.Load(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { requireSignatures = false }()
+
+	// With no ledger, block i lives on shard i % NumShards
+	own, foreign := 2, 1
+	sign := func(org string, source, target int, data, nonce string) SignedRequest {
+		id, signature, err := keyRegistry.Sign(org, signingPayload(source, target, data, nonce))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return SignedRequest{KeyID: id, Signature: signature}
+	}
+
+	tests := []struct {
+		name     string
+		signer   SignedRequest
+		source   int
+		required bool
+		nonce    string
+		want     error
+	}{
+		{"own shard", sign("Org1", own, 3, "x", "n1"), own, false, "n1", nil},
+		{"replayed nonce", sign("Org1", own, 3, "x", "n1"), own, false, "n1", ErrNonceReused},
+		{"new nonce, old signature", sign("Org1", own, 3, "x", "n1"), own, false, "n2", ErrBadSignature},
+		{"missing nonce", sign("Org1", own, 3, "x", ""), own, false, "", ErrNonceMissing},
+		{"foreign shard", sign("Org1", foreign, 3, "x", "n3"), foreign, false, "n3", ErrForeignShard},
+		{"tampered transfer", sign("Org1", own, 3, "x", "n4"), own, false, "n4", ErrBadSignature},
+		{"unknown key", SignedRequest{KeyID: "nope", Signature: "AA=="}, own, false, "n5", ErrUnknownKey},
+		{"unsigned, optional", SignedRequest{}, own, false, "", nil},
+		{"unsigned, required", SignedRequest{}, own, true, "", ErrSignatureMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireSignatures = tt.required
+			data := "x"
+			if tt.name == "tampered transfer" {
+				data = "y"
+			}
+			if err := tt.signer.verify(tt.source, 3, data, tt.nonce); !errors.Is(err, tt.want) {
+				t.Errorf("verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Versioned value held in the world state
+type VersionedValue struct {
+	Value   string `json:"value"`
+	Version int    `json:"version"`
+	TxID    string `json:"tx_id,omitempty"` // Transaction that wrote this version
+
+	// Writer metadata consulted by conflict policies
+	WriterPriority int   `json:"writer_priority,omitempty"`
+	WriterTS       int64 `json:"writer_ts,omitempty"` // Writer start time, UnixNano
+}
+
+// Key and version observed by a transaction during execution
+type ReadEntry struct {
+	Key     string `json:"key"`
+	Version int    `json:"version"`
+}
+
+// Key and value a transaction wants to write on commit
+type WriteEntry struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Versioned key-value world state. Every commit bumps the state height and
+// stamps the written keys with it, so a key's version is the height of the
+// commit that last wrote it (0 = never written).
+type WorldState struct {
+	mu     sync.RWMutex
+	data   map[string]VersionedValue
+	height int
+	locks  map[string]string // Key -> cross-shard transaction that prepared it
+	undo   []undoRecord      // One per commit, oldest first, for rollback
+	pruned int               // Undo records at or below this height are gone
+}
+
+// What a commit overwrote, so it can be undone, and what it wrote, so it
+// can be redone when an earlier commit is undone
+type undoRecord struct {
+	height int
+	txID   string
+	prev   map[string]VersionedValue // Zero value = key did not exist
+	next   map[string]VersionedValue
+}
+
+// Returned by Commit when a read version no longer matches the world state
+type StaleReadError struct {
+	Key            string
+	ReadVersion    int
+	CurrentVersion int
+	WriterTxID     string // Transaction that wrote the current version
+	Policy         string // Conflict policy that judged the stale read
+}
+
+func (e *StaleReadError) Error() string {
+	return fmt.Sprintf("stale read on %s: read version %d, current version %d", e.Key, e.ReadVersion, e.CurrentVersion)
+}
+
+// Returned when a key is held by a prepared cross-shard transaction
+type KeyLockedError struct {
+	Key    string
+	Holder string
+}
+
+func (e *KeyLockedError) Error() string {
+	return fmt.Sprintf("key %s is locked by prepared transaction %s", e.Key, e.Holder)
+}
+
+const defaultTransferAmount = 1 // Units moved by a transfer when none is given
+
+var worldState = NewWorldState()
+
+func NewWorldState() *WorldState {
+	return &WorldState{data: make(map[string]VersionedValue), locks: make(map[string]string)}
+}
+
+// World state key holding the balance of a block/node
+func accountKey(id int) string {
+	return fmt.Sprintf("acct-%d", id)
+}
+
+// Read a single key, returning version 0 for keys that were never written
+func (ws *WorldState) Get(key string) VersionedValue {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.data[key]
+}
+
+// Current commit height of the world state
+func (ws *WorldState) Height() int {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.height
+}
+
+// Copy of the whole state, used by the API and for debugging
+func (ws *WorldState) Snapshot() map[string]VersionedValue {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	snapshot := make(map[string]VersionedValue, len(ws.data))
+	for k, v := range ws.data {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Validate the read set against the current versions and apply the write
+// set atomically. A stale read aborts the commit unless the transaction's
+// conflict policy lets it through. Returns the commit height.
+func (ws *WorldState) Commit(meta CommitMeta, reads []ReadEntry, writes []WriteEntry) (int, error) {
+	height, _, err := ws.CommitOps(meta, reads, writes, nil)
+	return height, err
+}
+
+// Commit like Commit, additionally merging commutative operations into the
+// keys' values at commit time. Ops skip read-version validation but still
+// respect prepared-key locks. Returns the commit height and the writes the
+// ops resolved to.
+func (ws *WorldState) CommitOps(meta CommitMeta, reads []ReadEntry, writes []WriteEntry, ops []CommutativeOp) (int, []WriteEntry, error) {
+	ws.mu.Lock()
+	overrides, err := ws.validate(meta, reads, writes)
+	if err != nil {
+		ws.mu.Unlock()
+		return 0, nil, err
+	}
+
+	merged := make([]WriteEntry, 0, len(ops))
+	pending := make(map[string]string) // Ops on the same key see each other
+	var avoided []OpKind
+	for _, op := range ops {
+		if holder, locked := ws.locks[op.Key]; locked && holder != meta.TxID {
+			ws.mu.Unlock()
+			return 0, nil, &KeyLockedError{Key: op.Key, Holder: holder}
+		}
+		current, ok := pending[op.Key]
+		if !ok {
+			current = ws.data[op.Key].Value
+		}
+		value, err := op.merge(current)
+		if err != nil {
+			ws.mu.Unlock()
+			return 0, nil, err
+		}
+		pending[op.Key] = value
+		merged = append(merged, WriteEntry{Key: op.Key, Value: value})
+		if ws.data[op.Key].Version != op.BaseVersion {
+			avoided = append(avoided, op.Op)
+		}
+	}
+	height := ws.apply(meta, append(append([]WriteEntry(nil), writes...), merged...))
+	ws.mu.Unlock()
+
+	for _, op := range avoided {
+		avoidedConflicts.add(op)
+	}
+	recordOverrides(meta, overrides, writes)
+	return height, merged, nil
+}
+
+// Check read versions and prepared-key locks. Stale reads are put to the
+// conflict policy; those it allows are returned so they can be recorded.
+// Caller must hold ws.mu.
+func (ws *WorldState) validate(meta CommitMeta, reads []ReadEntry, writes []WriteEntry) ([]*StaleReadError, error) {
+	var overrides []*StaleReadError
+	policy := meta.policy()
+	for _, r := range reads {
+		if current := ws.data[r.Key]; current.Version != r.Version {
+			stale := &StaleReadError{Key: r.Key, ReadVersion: r.Version, CurrentVersion: current.Version, WriterTxID: current.TxID, Policy: policy.Name()}
+			if !policy.AllowStale(meta, current) {
+				return nil, stale
+			}
+			overrides = append(overrides, stale)
+		}
+		if holder, locked := ws.locks[r.Key]; locked && holder != meta.TxID {
+			return nil, &KeyLockedError{Key: r.Key, Holder: holder}
+		}
+	}
+	for _, w := range writes {
+		if holder, locked := ws.locks[w.Key]; locked && holder != meta.TxID {
+			return nil, &KeyLockedError{Key: w.Key, Holder: holder}
+		}
+	}
+	return overrides, nil
+}
+
+// Apply writes at a new height. Caller must hold ws.mu.
+func (ws *WorldState) apply(meta CommitMeta, writes []WriteEntry) int {
+	ws.height++
+	undo := undoRecord{
+		height: ws.height,
+		txID:   meta.TxID,
+		prev:   make(map[string]VersionedValue, len(writes)),
+		next:   make(map[string]VersionedValue, len(writes)),
+	}
+	for _, w := range writes {
+		if _, seen := undo.prev[w.Key]; !seen {
+			undo.prev[w.Key] = ws.data[w.Key]
+		}
+	}
+	var startedAt int64
+	if !meta.StartedAt.IsZero() {
+		startedAt = meta.StartedAt.UnixNano()
+	}
+	for _, w := range writes {
+		ws.data[w.Key] = VersionedValue{
+			Value:          w.Value,
+			Version:        ws.height,
+			TxID:           meta.TxID,
+			WriterPriority: meta.Priority,
+			WriterTS:       startedAt,
+		}
+		undo.next[w.Key] = ws.data[w.Key]
+	}
+	ws.undo = append(ws.undo, undo)
+	return ws.height
+}
+
+// First phase of a cross-shard commit: validate the shard's part of the
+// read/write sets and lock its keys until CommitPrepared or ReleaseLocks.
+// Stale reads the policy let through are returned for the caller to record
+// once it holds no shard lock.
+func (ws *WorldState) PrepareKeys(meta CommitMeta, reads []ReadEntry, writes []WriteEntry) ([]*StaleReadError, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	overrides, err := ws.validate(meta, reads, writes)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reads {
+		ws.locks[r.Key] = meta.TxID
+	}
+	for _, w := range writes {
+		ws.locks[w.Key] = meta.TxID
+	}
+	return overrides, nil
+}
+
+// Second phase: apply the prepared writes and drop the transaction's locks
+func (ws *WorldState) CommitPrepared(meta CommitMeta, writes []WriteEntry) int {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	height := ws.apply(meta, writes)
+	ws.releaseLocked(meta.TxID)
+	return height
+}
+
+// Drop every key lock held by the transaction
+func (ws *WorldState) ReleaseLocks(txID string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.releaseLocked(txID)
+}
+
+func (ws *WorldState) releaseLocked(txID string) {
+	for key, holder := range ws.locks {
+		if holder == txID {
+			delete(ws.locks, key)
+		}
+	}
+}
+
+// Validate and apply a whole batch in order. Each transaction gets its own
+// commit height; its reads of the world state are validated up front, under
+// its own conflict policy, against the state the batch was executed on. Any
+// stale read a policy refuses fails the whole batch. Returns the height of
+// the first transaction.
+func (ws *WorldState) CommitBatch(metas []CommitMeta, reads [][]ReadEntry, writes [][]WriteEntry) (int, error) {
+	ws.mu.Lock()
+	overrides := make([][]*StaleReadError, len(metas))
+	for i, meta := range metas {
+		var err error
+		if overrides[i], err = ws.validate(meta, reads[i], writes[i]); err != nil {
+			ws.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	first := ws.height + 1
+	for i, meta := range metas {
+		ws.apply(meta, writes[i])
+	}
+	ws.mu.Unlock()
+
+	for i, meta := range metas {
+		recordOverrides(meta, overrides[i], writes[i])
+	}
+	return first, nil
+}
+
+// Undo every commit above height, and every commit at or below it that
+// revert picks out (nil picks none). Commits are undone newest first back to
+// the oldest one that goes; the ones in between that stay are then redone
+// in order, with their original versions. Returns the IDs of the
+// transactions whose commits were undone, in commit order. The state height
+// is left where it was, so commits after the rollback never reuse the
+// version of an undone one.
+func (ws *WorldState) RollbackTo(height int, revert func(txID string) bool) ([]string, error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if height < ws.pruned {
+		return nil, fmt.Errorf("state below height %d is no longer kept for rollback", ws.pruned)
+	}
+	goes := func(record undoRecord) bool {
+		return record.height > height || (revert != nil && revert(record.txID))
+	}
+
+	cut := len(ws.undo)
+	for i := len(ws.undo) - 1; i >= 0; i-- {
+		if goes(ws.undo[i]) {
+			cut = i
+		}
+	}
+	for i := len(ws.undo) - 1; i >= cut; i-- {
+		for key, prev := range ws.undo[i].prev {
+			if prev.Version == 0 {
+				delete(ws.data, key)
+			} else {
+				ws.data[key] = prev
+			}
+		}
+	}
+
+	kept := append([]undoRecord(nil), ws.undo[:cut]...)
+	undone := make([]string, 0, len(ws.undo)-cut)
+	for _, record := range ws.undo[cut:] {
+		if goes(record) {
+			undone = append(undone, record.txID)
+			continue
+		}
+		for key, next := range record.next {
+			record.prev[key] = ws.data[key] // What it overwrites now
+			ws.data[key] = next
+		}
+		kept = append(kept, record)
+	}
+	ws.undo = kept
+	return undone, nil
+}
+
+// Drop the undo records at or below height. The state can no longer be
+// rolled back below it.
+func (ws *WorldState) PruneUndo(height int) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if height <= ws.pruned {
+		return
+	}
+	cut := 0
+	for cut < len(ws.undo) && ws.undo[cut].height <= height {
+		cut++
+	}
+	ws.undo = append([]undoRecord(nil), ws.undo[cut:]...)
+	ws.pruned = height
+}
+
+// Lowest height the state can be rolled back to
+func (ws *WorldState) UndoFloor() int {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return ws.pruned
+}
+
+// Parse a balance value, treating missing or malformed values as zero
+func parseBalance(value string) int64 {
+	if value == "" {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Simulate a transfer against a state reader (usually worldState.Get) and
+// capture the read set and the resulting write set. Nothing is applied
+// until Commit.
+func executeTransfer(get func(key string) VersionedValue, source, target int, amount int64) ([]ReadEntry, []WriteEntry) {
+	srcKey, tgtKey := accountKey(source), accountKey(target)
+	src, tgt := get(srcKey), get(tgtKey)
+
+	reads := []ReadEntry{
+		{Key: srcKey, Version: src.Version},
+		{Key: tgtKey, Version: tgt.Version},
+	}
+	writes := []WriteEntry{
+		{Key: srcKey, Value: strconv.FormatInt(parseBalance(src.Value)-amount, 10)},
+		{Key: tgtKey, Value: strconv.FormatInt(parseBalance(tgt.Value)+amount, 10)},
+	}
+	return reads, writes
+}
+
+// API to inspect the versioned world state
+func getWorldState(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"height": worldState.Height(),
+		"state":  worldState.Snapshot(),
+	})
+}
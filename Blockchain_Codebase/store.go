@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Durable storage for transaction logs, blocks and conflict records. The
+// node runs against Firestore, an embedded key-value file or memory alone,
+// chosen with -store; the default uses Firestore when its credentials are
+// present and the key-value file otherwise, so the node also runs offline.
+type Store interface {
+	Name() string
+	SaveTransaction(entry TransactionLog) error
+	LoadTransactions() ([]TransactionLog, error) // In clock order
+	SaveBlock(block Block) error                 // Replaces the block with the same index
+	TruncateBlocks(height int) error             // Deletes every block from index height on
+	LoadBlocks() ([]Block, error)                // In index order
+	SaveConflict(record ConflictRecord) error    // Replaces the record with the same ID
+	LoadConflicts() ([]ConflictRecord, error)    // In ID order
+	Close() error
+}
+
+// Store settings; each flag defaults to its environment variable
+var (
+	storeBackend        = envOr("BLOCKCHAIN_STORE", "auto")
+	storePath           = envOr("BLOCKCHAIN_STORE_PATH", "store.db")
+	firebaseCredentials = envOr("FIREBASE_CREDENTIALS", "csc4006-serviceAccount.json")
+	firebaseProjectID   = envOr("FIREBASE_PROJECT_ID", "") // Empty = project of the credentials
+)
+
+// Store in use; memory until main opens the configured one
+var store Store = NewMemoryStore()
+
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+// Open the store named by backend: auto, firestore, kv or memory
+func openStore(backend string) (Store, error) {
+	switch backend {
+	case "auto":
+		if _, err := os.Stat(firebaseCredentials); err != nil {
+			log.Printf("⚠️ No Firebase credentials at %s, storing data in %s", firebaseCredentials, storePath)
+			return OpenKVStore(storePath)
+		}
+		s, err := NewFirestoreStore(firebaseCredentials, firebaseProjectID)
+		if err != nil {
+			log.Printf("⚠️ Firestore unavailable (%v), storing data in %s", err, storePath)
+			return OpenKVStore(storePath)
+		}
+		return s, nil
+	case "firestore":
+		return NewFirestoreStore(firebaseCredentials, firebaseProjectID)
+	case "kv":
+		return OpenKVStore(storePath)
+	case "memory":
+		return NewMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store %q: use auto, firestore, kv or memory", backend)
+}
+
+// Store that keeps everything in memory and loses it on exit
+type MemoryStore struct {
+	mu           sync.Mutex
+	transactions []TransactionLog
+	blocks       map[int]Block
+	conflicts    map[int]ConflictRecord
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blocks: make(map[int]Block), conflicts: make(map[int]ConflictRecord)}
+}
+
+func (s *MemoryStore) Name() string { return "memory" }
+
+func (s *MemoryStore) Close() error { return nil }
+
+func (s *MemoryStore) SaveTransaction(entry TransactionLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transactions = append(s.transactions, entry)
+	return nil
+}
+
+func (s *MemoryStore) LoadTransactions() ([]TransactionLog, error) {
+	s.mu.Lock()
+	logs := append([]TransactionLog(nil), s.transactions...)
+	s.mu.Unlock()
+	sortLogsByHLC(logs)
+	return logs, nil
+}
+
+func (s *MemoryStore) SaveBlock(block Block) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocks[block.Index] = block
+	return nil
+}
+
+func (s *MemoryStore) TruncateBlocks(height int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for index := range s.blocks {
+		if index >= height {
+			delete(s.blocks, index)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) LoadBlocks() ([]Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blocks := make([]Block, 0, len(s.blocks))
+	for _, block := range s.blocks {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Index < blocks[j].Index })
+	return blocks, nil
+}
+
+func (s *MemoryStore) SaveConflict(record ConflictRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conflicts[record.ID] = record
+	return nil
+}
+
+func (s *MemoryStore) LoadConflicts() ([]ConflictRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]ConflictRecord, 0, len(s.conflicts))
+	for _, record := range s.conflicts {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Transaction IDs are derived from the transaction itself: the hash of its
+// canonical content, a nonce and the submitter. Resubmitting the same
+// transfer with the same nonce yields the same ID, so the node can tell it
+// is a duplicate. Clients that send no nonce get a random one and are never
+// deduplicated. IDs in the old tx-<UnixNano> format are still accepted.
+
+// Inputs of a content-derived ID besides the transfer itself
+type TxOrigin struct {
+	Nonce     string `json:"nonce,omitempty"`
+	Submitter string `json:"submitter,omitempty"` // Signing key ID for signed transactions
+}
+
+var (
+	legacyTxIDPattern  = regexp.MustCompile(`^tx-\d{1,19}(-\d+)?$`)
+	contentTxIDPattern = regexp.MustCompile(`^tx-[0-9a-f]{32}$`)
+)
+
+var (
+	ErrTxIDMismatch = errors.New("transaction ID does not match its content")
+	ErrBadTxID      = errors.New("transaction ID must be content-derived or tx-<UnixNano>")
+)
+
+// ID of a transfer with the given origin
+func contentTxID(source, target int, data string, origin TxOrigin) string {
+	h := sha256.New()
+	h.Write(transferPayload(source, target, data))
+	h.Write([]byte{0})
+	h.Write([]byte(origin.Nonce))
+	h.Write([]byte{0})
+	h.Write([]byte(origin.Submitter))
+	return "tx-" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Complete the origin of a submission: a signed transaction is submitted by
+// its key, and a missing nonce is replaced with a random one
+func newTxOrigin(origin TxOrigin, signer SignedRequest) TxOrigin {
+	if signer.KeyID != "" {
+		origin.Submitter = signer.KeyID
+	}
+	if origin.Nonce == "" {
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+		origin.Nonce = hex.EncodeToString(nonce)
+	}
+	return origin
+}
+
+// ID to use for a submission. An empty requested ID gets the derived one;
+// a legacy ID is kept as is; a content-derived ID must match the content.
+func resolveTxID(requested string, source, target int, data string, origin TxOrigin) (string, error) {
+	derived := contentTxID(source, target, data, origin)
+	switch {
+	case requested == "" || requested == derived:
+		return derived, nil
+	case legacyTxIDPattern.MatchString(requested):
+		return requested, nil
+	case contentTxIDPattern.MatchString(requested):
+		return "", fmt.Errorf("%w: expected %s", ErrTxIDMismatch, derived)
+	}
+	return "", fmt.Errorf("%w, got %q", ErrBadTxID, requested)
+}
+
+// Statuses after which the same transaction may be submitted again
+var resubmittable = map[string]bool{"failed": true, "aborted": true, statusReverted: true, statusBudgetExceeded: true}
+
+// Mark a new transaction pending. Returns false and the current status if
+// the ID is already pending or committed.
+func claimTransaction(txID string) (string, bool) {
+	TransactionMu.Lock()
+	defer TransactionMu.Unlock()
+	if status, exists := transactionStatus[txID]; exists && !resubmittable[status] {
+		return status, false
+	}
+	transactionStatus[txID] = "pending"
+	return "pending", true
+}
+
+// Claim every transaction of a batch, dropping duplicates of earlier
+// submissions or of each other. Returns the new transactions and the IDs
+// of the dropped ones.
+func claimBatch(batch []BatchTx) ([]BatchTx, []string) {
+	claimed := make([]BatchTx, 0, len(batch))
+	duplicates := make([]string, 0)
+	for _, tx := range batch {
+		if _, ok := claimTransaction(tx.TransactionID); ok {
+			claimed = append(claimed, tx)
+		} else {
+			duplicates = append(duplicates, tx.TransactionID)
+		}
+	}
+	return claimed, duplicates
+}
+
+// Respond to a duplicate submission
+func rejectDuplicate(c *gin.Context, txID, status string) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":         "duplicate transaction",
+		"transactionID": txID,
+		"status":        status,
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// Write-ahead log of transaction execution. Every transaction logs its
+// submission, each execution attempt, and its commit to the world state or
+// its abort, fsynced before the step takes effect. On restart the log is
+// replayed against the loaded ledger, and the outcome depends on the log
+// alone: a transaction whose commit was logged is finished by appending it
+// to the ledger if it is missing, and one that never got that far is
+// aborted. Once the replayed ledger is saved the log starts afresh, and
+// every later ledger save drops the records of transactions it settles.
+
+const walFile = "transactions.wal"
+
+// Stages of a transaction in the write-ahead log
+const (
+	walSubmit  = "submit"
+	walExecute = "execute"
+	walCommit  = "commit"
+	walAbort   = "abort"
+)
+
+// One line of the write-ahead log
+type walRecord struct {
+	Stage   string      `json:"stage"`
+	HLC     HLC         `json:"hlc"`
+	Tx      Transaction `json:"tx"`                // As submitted, executed or committed
+	Outcome string      `json:"outcome,omitempty"` // Status an abort leaves behind
+	Reason  string      `json:"reason,omitempty"`
+}
+
+// Durable, append-only write-ahead log
+type WAL struct {
+	mu   sync.Mutex
+	path string // Empty disables the log
+	file *os.File
+	size int64 // Bytes in the log
+}
+
+var txWAL = &WAL{path: walFile}
+
+// Append a record and fsync it before returning. Failures are logged; the
+// transaction goes ahead without the protection of the log.
+func (w *WAL) Append(record walRecord) {
+	if err := w.append(record); err != nil {
+		log.Printf("❌ Failed to log %s of %s: %v", record.Stage, record.Tx.TransactionID, err)
+	}
+}
+
+func (w *WAL) append(record walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.path == "" {
+		return nil
+	}
+	if w.file == nil {
+		f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.file, w.size = f, info.Size()
+	}
+	if record.HLC.IsZero() {
+		record.HLC = hybridClock.Now()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	n, err := w.file.Write(append(line, '\n'))
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Log the submission of a transaction
+func (w *WAL) Submit(tx Transaction) {
+	w.Append(walRecord{Stage: walSubmit, Tx: tx})
+}
+
+// Log the terminal failure of a transaction
+func (w *WAL) Abort(txID, outcome, reason string) {
+	w.Append(walRecord{Stage: walAbort, Tx: Transaction{TransactionID: txID}, Outcome: outcome, Reason: reason})
+}
+
+// Records of every transaction in the log, in the order the transactions
+// were first logged
+func (w *WAL) Load() ([][]walRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(w.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	position := make(map[string]int)
+	var history [][]walRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("⚠️ Skipping corrupt write-ahead log line: %v", err)
+			continue // A torn final write after a crash
+		}
+		i, ok := position[record.Tx.TransactionID]
+		if !ok {
+			i = len(history)
+			position[record.Tx.TransactionID] = i
+			history = append(history, nil)
+		}
+		history[i] = append(history[i], record)
+	}
+	return history, scanner.Err()
+}
+
+// Each logged transaction as it was last submitted
+func (w *WAL) Submissions() map[string]Transaction {
+	submitted := make(map[string]Transaction)
+	history, err := w.Load()
+	if err != nil {
+		log.Printf("⚠️ Failed to read the write-ahead log: %v", err)
+	}
+	for _, records := range history {
+		for _, record := range records {
+			if record.Stage == walSubmit {
+				submitted[record.Tx.TransactionID] = record.Tx
+			}
+		}
+	}
+	return submitted
+}
+
+// Start the log afresh once everything in it is reflected in the saved
+// ledger
+func (w *WAL) Reset() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.path == "" {
+		return nil
+	}
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return writeFileAtomic(w.path, nil)
+}
+
+// Length of the log so far. Records appended after the call lie past it.
+func (w *WAL) Mark() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil && w.path != "" {
+		if info, err := os.Stat(w.path); err == nil {
+			return info.Size()
+		}
+	}
+	return w.size
+}
+
+// Drop the records before mark of transactions whose saved status is final.
+// The ledger saved with those statuses now carries what their records
+// would have recovered. Records past mark are always kept: they may belong
+// to a resubmission the save did not see.
+func (w *WAL) Compact(mark int64, statuses map[string]string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mark = min(mark, int64(len(data)))
+
+	var kept bytes.Buffer
+	dropped := 0
+	for _, line := range bytes.SplitAfter(data[:mark], []byte{'\n'}) {
+		var record walRecord
+		if len(line) == 0 {
+			continue
+		}
+		if json.Unmarshal(line, &record) == nil {
+			if status, ok := statuses[record.Tx.TransactionID]; ok && status != "pending" {
+				dropped++
+				continue
+			}
+		}
+		kept.Write(line)
+	}
+	if dropped == 0 {
+		return nil
+	}
+	kept.Write(data[mark:])
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	if err := writeFileAtomic(w.path, kept.Bytes()); err != nil {
+		return err
+	}
+	w.size = int64(kept.Len())
+	return nil
+}
+
+// After a restart, finish or abort every transaction the write-ahead log
+// left in flight, then save the ledger and reset the log. Must run after
+// the ledger is loaded and before new transactions are accepted.
+func recoverTransactions() {
+	history, err := txWAL.Load()
+	if err != nil {
+		log.Printf("❌ Failed to read write-ahead log: %v", err)
+		return
+	}
+	if len(history) == 0 {
+		return
+	}
+	coordRecords, err := coordinatorLog.Load()
+	if err != nil {
+		log.Printf("❌ Failed to read coordinator log: %v", err)
+	}
+	decisions := make(map[string]coordRecord)
+	for _, record := range coordRecords {
+		decisions[record.TxID] = record
+	}
+
+	finished, aborted := 0, 0
+	for _, records := range history {
+		last := records[len(records)-1]
+		txID := last.Tx.TransactionID
+		if last.Stage == walAbort {
+			setStatus(txID, last.Outcome)
+			continue
+		}
+		// Only the latest submission counts; earlier ones were aborted
+		for i := len(records) - 1; i >= 0; i-- {
+			if records[i].Stage == walAbort {
+				records = records[i+1:]
+				break
+			}
+		}
+		committed, ok := committedTransaction(records, decisions[txID])
+		switch {
+		case ok:
+			if _, inLedger := findLedgerTransaction(txID); !inLedger {
+				BlockchainMu.Lock()
+				blockProducer.add(committed)
+				BlockchainMu.Unlock()
+				log.Printf("🩹 Recovered in-flight %s: committed", txID)
+				finished++
+			}
+			setStatus(txID, "completed")
+		default:
+			setStatus(txID, "aborted")
+			log.Printf("🩹 Recovered in-flight %s: aborted at %s", txID, last.Stage)
+			aborted++
+		}
+	}
+
+	if err := ledgerStore.Save(); err != nil {
+		log.Printf("❌ Failed to save recovered ledger, keeping the write-ahead log: %v", err)
+		return
+	}
+	if err := txWAL.Reset(); err != nil {
+		log.Printf("❌ Failed to reset write-ahead log: %v", err)
+	}
+	log.Printf("🩹 Replayed write-ahead log: %d transactions, %d finished, %d aborted", len(history), finished, aborted)
+}
+
+// Ledger entry of a transaction whose commit reached the write-ahead log,
+// or that the cross-shard coordinator decided to commit
+func committedTransaction(records []walRecord, decision coordRecord) (Transaction, bool) {
+	for _, record := range records {
+		if record.Stage == walCommit {
+			tx := record.Tx
+			tx.HLC = record.HLC
+			return tx, true
+		}
+	}
+	if decision.State != twoPCCommit && decision.State != twoPCEnd {
+		return Transaction{}, false
+	}
+	tx := records[0].Tx // The submission
+	tx.WriteSet = nil
+	for _, shardID := range decision.Participants {
+		tx.WriteSet = append(tx.WriteSet, decision.Writes[shardID]...)
+	}
+	tx.Status, tx.Outcome = "completed", OutcomeCommitted
+	tx.HLC = records[len(records)-1].HLC
+	// Replay at the height the commit was applied at. Without an end record
+	// that height was never logged; replay after everything in the ledger.
+	tx.Version = decision.Height
+	if tx.Version == 0 {
+		tx.Version = ledgerStateHeight() + 1
+	}
+	return tx, true
+}
+
+// Highest commit height of a transaction the ledger replays into the world
+// state
+func ledgerStateHeight() int {
+	BlockchainMu.Lock()
+	defer BlockchainMu.Unlock()
+	height := 0
+	for _, block := range Blockchain {
+		for _, tx := range block.Transactions {
+			if len(tx.WriteSet) > 0 { // Only these are replayed
+				height = max(height, tx.Version)
+			}
+		}
+	}
+	return height
+}
+
+func setStatus(txID, status string) {
+	TransactionMu.Lock()
+	transactionStatus[txID] = status
+	TransactionMu.Unlock()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCommittedTransactionFromDecisionKeepsReplayOrder(t *testing.T) {
+	chain := Blockchain
+	t.Cleanup(func() { Blockchain = chain })
+	Blockchain = []Block{{Index: 0, Transactions: []Transaction{
+		{TransactionID: "earlier", Version: 7, WriteSet: []WriteEntry{{Key: "acct-1", Value: "1"}}},
+	}}}
+	records := []walRecord{{Stage: walSubmit, Tx: Transaction{TransactionID: "tx-2pc", Source: 1, Target: 2}}}
+	writes := map[int][]WriteEntry{0: {{Key: "acct-1", Value: "0"}}, 1: {{Key: "acct-2", Value: "2"}}}
+
+	tests := []struct {
+		name     string
+		decision coordRecord
+		want     int
+	}{
+		{"ended at a known height", coordRecord{TxID: "tx-2pc", State: twoPCEnd, Participants: []int{0, 1}, Writes: writes, Height: 5}, 5},
+		{"decided, never ended", coordRecord{TxID: "tx-2pc", State: twoPCCommit, Participants: []int{0, 1}, Writes: writes}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx, ok := committedTransaction(records, tt.decision)
+			if !ok {
+				t.Fatal("a decided commit was not recovered")
+			}
+			if tx.Version != tt.want {
+				t.Errorf("recovered at version %d, want %d", tx.Version, tt.want)
+			}
+			if len(tx.WriteSet) != 2 {
+				t.Errorf("recovered %d writes, want 2", len(tx.WriteSet))
+			}
+		})
+	}
+}
+
+func TestWALCompactDropsSettledTransactions(t *testing.T) {
+	wal := &WAL{path: filepath.Join(t.TempDir(), walFile)}
+	wal.Submit(Transaction{TransactionID: "settled"})
+	wal.Append(walRecord{Stage: walCommit, Tx: Transaction{TransactionID: "settled"}})
+	wal.Submit(Transaction{TransactionID: "in-flight"})
+	mark := wal.Mark()
+	wal.Submit(Transaction{TransactionID: "settled"}) // Resubmitted after the save captured its status
+
+	if err := wal.Compact(mark, map[string]string{"settled": "completed", "in-flight": "pending"}); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+	wal.Submit(Transaction{TransactionID: "later"})
+
+	history, err := wal.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got := make(map[string]int)
+	for _, records := range history {
+		got[records[0].Tx.TransactionID] = len(records)
+	}
+	want := map[string]int{"in-flight": 1, "settled": 1, "later": 1}
+	if len(got) != len(want) {
+		t.Fatalf("log holds %v, want %v", got, want)
+	}
+	for txID, n := range want {
+		if got[txID] != n {
+			t.Errorf("%s has %d records, want %d", txID, got[txID], n)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	//"blockchain/blockchain_test"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -11,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"sync"
 	"syscall"
@@ -31,13 +33,18 @@ type TransactionLog struct {
 	ExecTime    float64 `json:"execTime"`
 	Finality    float64 `json:"finalityTime"`
 	Timestamp   string  `json:"timestamp"`
+	HLC         HLC     `json:"hlc"` // Hybrid logical clock; logs are ordered by it
 	Propagation float64 `json:"propagationLatency"`
 	TPS         float64 `json:"tps"`
+	Attempts    int     `json:"attempts"`
+	Outcome     string  `json:"outcome"`
+	Policy      string  `json:"deadlockPolicy,omitempty"`
+	Mode        string  `json:"crossShardMode,omitempty"`
+	Conflict    string  `json:"conflictPolicy,omitempty"`
 }
 
 const (
 	blockchainFile  = "blockchain.json"
-	maxRetries      = 3
 	maxSegmentSize  = 10
 	shutdownTimeout = 5 * time.Second
 )
@@ -51,7 +58,7 @@ var (
 	TransactionPool     = make(map[string]*Transaction)
 	transactionStatus   = make(map[string]string)
 	TransactionMu       sync.Mutex
-	transactionSegments = make(map[string][]TransactionSegment)
+	transactionSegments = make(map[string]*segmentAssembly)
 
 	// Performance measurement benchmarks
 	txCount      int
@@ -77,8 +84,17 @@ func getExecutionOptions(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"options": executionOptions})
 }
 func executeTransaction(c *gin.Context) {
+	if rejectUnsignable(c) { // Transfers are picked at random
+		return
+	}
 	var request struct {
-		Option int `json:"option"`
+		Option         int    `json:"option"`
+		DeadlockPolicy string `json:"deadlock_policy"`
+		CrossShardMode string `json:"cross_shard_mode"`
+		ConflictPolicy string `json:"conflict_policy"`
+		Priority       int    `json:"priority"` // Weighed by the priority policy and lowest-priority victims
+		Commutative    bool   `json:"commutative"`
+		Floor          *int64 `json:"floor"`
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -86,6 +102,14 @@ func executeTransaction(c *gin.Context) {
 		return
 	}
 
+	opts, err := execOptionsFromRequest(request.DeadlockPolicy, request.CrossShardMode, request.ConflictPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Priority = request.Priority
+	opts.Commutative, opts.Floor = request.Commutative, request.Floor
+
 	startTime := time.Now()
 
 	var (
@@ -136,8 +160,10 @@ func executeTransaction(c *gin.Context) {
 		return
 	}
 
-	transactionID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
-	go processTransaction(transactionID, sourceBlock, targetBlock, "Transaction Data", isSharded)
+	opts.Origin = newTxOrigin(TxOrigin{}, SignedRequest{})
+	transactionID := contentTxID(sourceBlock, targetBlock, "Transaction Data", opts.Origin)
+	claimTransaction(transactionID) // A fresh nonce never collides
+	go processTransaction(transactionID, sourceBlock, targetBlock, "Transaction Data", isSharded, opts)
 
 	executionTime := time.Since(startTime).Seconds()
 	finalityTime := executionTime * 1000 // in ms
@@ -145,11 +171,15 @@ func executeTransaction(c *gin.Context) {
 	log.Printf("Finality time for %s: %.2f ms", transactionID, finalityTime)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":        message,
-		"execution_time": executionTime,
-		"source_block":   sourceBlock,
-		"target_block":   targetBlock,
-		"is_sharded":     isSharded,
+		"message":          message,
+		"execution_time":   executionTime,
+		"source_block":     sourceBlock,
+		"target_block":     targetBlock,
+		"is_sharded":       isSharded,
+		"deadlock_policy":  opts.DeadlockPolicy,
+		"cross_shard_mode": opts.CrossShardMode,
+		"conflict_policy":  opts.ConflictPolicy.Name(),
+		"commutative":      opts.Commutative,
 	})
 }
 
@@ -158,7 +188,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-HLC")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Genesis-Hash, X-HLC")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
@@ -173,43 +204,241 @@ func init() {
 	flag.StringVar(&port, "port", "8080", "Port number to run the server")
 	flag.BoolVar(&process, "process", false, "Process containers and add to blockchain")
 	flag.BoolVar(&server, "server", false, "Run REST API server for inspecting containers")
-
-	flag.Parse()
-	initShards() // Ensure sharding system is initialized
+	flag.IntVar(&retryPolicy.MaxRetries, "max-retries", maxRetryAttempts, "Retries for a transaction aborted on a version conflict")
+	flag.Var(&retryPolicy.Backoff, "retry-backoff", "Backoff between retries: fixed, exponential or jittered")
+	flag.DurationVar(&retryPolicy.BaseDelay, "retry-base-delay", 50*time.Millisecond, "Base delay between retries")
+	flag.DurationVar(&retryPolicy.MaxDelay, "retry-max-delay", 2*time.Second, "Upper bound on the delay between retries")
+	flag.DurationVar(&twoPCTimeout, "twopc-timeout", 5*time.Second, "Prepare timeout and in-doubt resolution interval for cross-shard 2PC")
+	flag.Var(&defaultCrossShardMode, "cross-shard-mode", "Cross-shard protocol: 2pc or receipts")
+	flag.Func("conflict-policy", "Conflict resolution at commit: first-committer-wins, last-writer-wins, priority or timestamp-ordering", func(value string) error {
+		policy, err := conflictPolicyByName(value)
+		if err == nil {
+			defaultConflictPolicy = policy
+		}
+		return err
+	})
+	flag.StringVar(&cryptoConfigDir, "crypto-config", cryptoConfigDir, "fablo crypto-config directory searched for Ed25519 signing keys")
+	flag.StringVar(&keyDir, "key-dir", keyDir, "Directory for locally generated signing keys")
+	flag.BoolVar(&requireSignatures, "require-signatures", false, "Reject unsigned transactions on the submission endpoints")
+	flag.IntVar(&blockProducer.MaxTransactions, "block-max-txs", maxTransactionsPerBlock, "Transactions per block before it is sealed (0 = no limit)")
+	flag.IntVar(&blockProducer.MaxBytes, "block-max-bytes", 64<<10, "Encoded transaction bytes per block before it is sealed (0 = no limit)")
+	flag.DurationVar(&blockProducer.MaxAge, "block-max-age", 10*time.Second, "How long a block stays open before it is sealed (0 = no limit)")
+	flag.DurationVar(&beaconChain.Interval, "beacon-interval", 5*time.Second, "How often the beacon chain commits shard chain heads")
+	flag.IntVar(&batchWorkers, "batch-workers", runtime.NumCPU(), "Workers used by the parallel batch execution engine")
+	flag.Var(&defaultDeadlockPolicy, "deadlock-policy", "Deadlock handling: detect, wound-wait or wait-die")
+	flag.Var(&lockManager.VictimPolicy, "victim-policy", "Deadlock victim: youngest, fewest-writes or lowest-priority")
+	flag.Var(&lockManager.Detection, "deadlock-detection", "When to search for deadlocks: on-request or periodic")
+	flag.DurationVar(&lockManager.Interval, "deadlock-interval", 100*time.Millisecond, "Scan interval for periodic deadlock detection")
+
+	flag.IntVar(&txBudget.MaxPayloadBytes, "tx-max-payload", txBudget.MaxPayloadBytes, "Payload bytes a transaction may carry (0 = no limit)")
+	flag.IntVar(&txBudget.MaxOps, "tx-max-ops", txBudget.MaxOps, "World state keys and ops a transaction may touch (0 = no limit)")
+	flag.DurationVar(&txBudget.MaxExecTime, "tx-max-exec-time", txBudget.MaxExecTime, "Execution time a transaction may use before it commits (0 = no limit)")
+	flag.IntVar(&blockBudget.MaxPayloadBytes, "block-max-payload", blockBudget.MaxPayloadBytes, "Total payload bytes of a block's transactions (0 = no limit)")
+	flag.IntVar(&blockBudget.MaxOps, "block-max-ops", blockBudget.MaxOps, "Total ops of a block's transactions (0 = no limit)")
+	flag.DurationVar(&blockBudget.MaxExecTime, "block-max-exec-time", blockBudget.MaxExecTime, "Total execution time of a block's transactions (0 = no limit)")
+	flag.StringVar(&genesisFile, "genesis", genesisFile, "Genesis file (JSON, or YAML by extension) declaring shards, orgs, accounts and chain parameters")
+	flag.StringVar(&storeBackend, "store", storeBackend, "Storage backend: auto (Firestore if its credentials exist, else kv), firestore, kv or memory")
+	flag.StringVar(&storePath, "store-path", storePath, "File of the embedded key-value store")
+	flag.StringVar(&firebaseCredentials, "firebase-credentials", firebaseCredentials, "Firebase service account file")
+	flag.StringVar(&firebaseProjectID, "firebase-project", firebaseProjectID, "Firebase project ID (empty = the service account's project)")
+	flag.IntVar(&firestoreWriterConfig.QueueSize, "firestore-queue", firestoreWriterConfig.QueueSize, "Transaction logs queued for Firestore before new ones are dropped")
+	flag.IntVar(&firestoreWriterConfig.BatchSize, "firestore-batch", firestoreWriterConfig.BatchSize, "Transaction logs written to Firestore per batch")
+	flag.DurationVar(&firestoreWriterConfig.FlushInterval, "firestore-flush-interval", firestoreWriterConfig.FlushInterval, "Longest a queued transaction log waits for its batch to fill")
+	flag.StringVar(&firestoreWriterConfig.SpillPath, "firestore-spill", firestoreWriterConfig.SpillPath, "File transaction logs are spilled to while Firestore is unreachable (empty = drop them)")
+	flag.StringVar(&txWAL.path, "wal-file", walFile, "Write-ahead log of in-flight transactions (empty = no log)")
+	flag.StringVar(&ledgerStore.Path, "ledger-file", blockchainFile, "File the ledger, shard assignments and transaction statuses are saved to (empty = not saved)")
+	flag.DurationVar(&segmentAssemblyTTL, "segment-ttl", segmentAssemblyTTL, "How long a transaction may take to receive all its segments before the partial assembly is dropped")
+	flag.IntVar(&rollbackDepth, "rollback-depth", rollbackDepth, "Sealed blocks a rollback can undo; older world state undo records are pruned (0 = no limit)")
 }
 
-func processTransaction(transactionID string, source int, target int, data string, isSharded bool) {
+func processTransaction(transactionID string, source int, target int, data string, isSharded bool, opts ExecOptions) {
 	startTime := time.Now()
 	// Use the intended sharding type for labelling
 	typeLabel := map[bool]string{true: "Sharded", false: "Non-Sharded"}[isSharded]
+	txWAL.Submit(Transaction{
+		TransactionID: transactionID,
+		TxOrigin:      opts.Origin,
+		Source:        source,
+		Target:        target,
+		Data:          data,
+		Status:        "pending",
+		Type:          typeLabel,
+		Priority:      opts.Priority,
+		SignerKeyID:   opts.Signer.KeyID,
+		Signature:     opts.Signer.Signature,
+	})
 
 	// Simulate execution in a goroutine
 	go func() {
-		// Simulate processing time
-		if isSharded {
-			time.Sleep(time.Duration(1+rand.Intn(2)) * time.Second)
-		} else {
-			time.Sleep(time.Duration(3+rand.Intn(4)) * time.Second)
-		}
 		BlockchainMu.Lock()
-		defer BlockchainMu.Unlock()
+		sourceExists := findBlockByIndex(source) != nil
+		BlockchainMu.Unlock()
+		if !sourceExists {
+			log.Printf("❌ ERROR: Source block %d not found for transaction %s", source, transactionID)
+			txWAL.Abort(transactionID, "failed", "source block not found")
+			TransactionMu.Lock()
+			transactionStatus[transactionID] = "failed"
+			TransactionMu.Unlock()
+			return
+		}
 
-		var sourceBlock *Block
-		for i := range Blockchain {
-			if Blockchain[i].Index == source {
-				sourceBlock = &Blockchain[i]
+		// Cross-shard transfers go through 2PC or, in receipts mode, commit
+		// the debit here and leave the credit to the target shard
+		crossShardMode := ""
+		if isCrossShard(source, target) {
+			crossShardMode = string(opts.CrossShardMode)
+		}
+		useReceipts := opts.CrossShardMode == CrossShardReceipts && crossShardMode != ""
+		receipt := newReceipt(transactionID, source, target, defaultTransferAmount)
+		// Commutative ops need no read validation, but only apply within a
+		// shard; cross-shard transfers keep their protocol's validation
+		useCommutative := opts.Commutative && crossShardMode == ""
+
+		var (
+			readSet  []ReadEntry
+			writeSet []WriteEntry
+			ops      []CommutativeOp
+			usage    ResourceUsage
+			version  int
+			attempts int
+			err      error
+		)
+		for attempts = 1; ; attempts++ {
+			attemptStart := time.Now() // Only this attempt counts against the budget
+			// Execute against a fresh snapshot, capturing the read/write sets
+			if useReceipts {
+				readSet, writeSet = executeDebit(worldState.Get, receipt)
+			} else if useCommutative {
+				ops = executeCommutativeTransfer(worldState.Get, transactionID, source, target, defaultTransferAmount, opts.Floor)
+			} else {
+				readSet, writeSet = executeTransfer(worldState.Get, source, target, defaultTransferAmount)
+			}
+
+			// Simulate processing time
+			if isSharded {
+				time.Sleep(time.Duration(1+rand.Intn(2)) * time.Second)
+			} else {
+				time.Sleep(time.Duration(3+rand.Intn(4)) * time.Second)
+			}
+			txWAL.Append(walRecord{Stage: walExecute, Tx: Transaction{
+				TransactionID: transactionID,
+				Attempts:      attempts,
+				ReadSet:       readSet,
+				WriteSet:      writeSet,
+				Ops:           ops,
+			}})
+
+			// Fail before committing anything over the execution budget
+			usage = ResourceUsage{PayloadBytes: len(data), Ops: len(readSet) + len(writeSet) + len(ops), ExecTime: msSince(attemptStart)}
+			if err = checkTxBudget(usage); err != nil {
+				break
+			}
+
+			// Lock both blocks, then MVCC validation: abort if any key read
+			// during execution has since changed
+			if useReceipts {
+				version, err = commitDebit(transactionID, startTime, opts, receipt, readSet, writeSet)
+			} else if useCommutative {
+				version, writeSet, err = commitCommutative(transactionID, startTime, opts, source, target, ops)
+			} else {
+				version, err = commitTransfer(transactionID, startTime, opts, source, target, readSet, writeSet)
+			}
+			if err == nil {
 				break
 			}
+			var stale *StaleReadError
+			if errors.As(err, &stale) {
+				resolution := fmt.Sprintf("aborted %s, retrying (attempt %d)", transactionID, attempts)
+				if attempts > retryPolicy.MaxRetries {
+					resolution = fmt.Sprintf("aborted %s after %d attempts", transactionID, attempts)
+				}
+				recordConflict(ConflictRecord{
+					Kind:       staleReadKind(stale, writeSet),
+					TxIDs:      []string{transactionID, stale.WriterTxID},
+					Keys:       []string{stale.Key},
+					Blocks:     []int{source, target},
+					Resolution: resolution,
+					Policy:     stale.Policy,
+					Detail: fmt.Sprintf("%s (%s) read %s v%d, now v%d",
+						transactionID, typeLabel, stale.Key, stale.ReadVersion, stale.CurrentVersion),
+				})
+			}
+			// A debit below its floor fails the same way on every attempt
+			var floor *FloorError
+			if attempts > retryPolicy.MaxRetries || errors.As(err, &floor) {
+				break
+			}
+			delay := retryPolicy.Delay(attempts)
+			log.Printf("🔁 Retrying %s in %v (attempt %d/%d): %v",
+				transactionID, delay, attempts+1, retryPolicy.MaxRetries+1, err)
+			time.Sleep(delay)
 		}
-		if sourceBlock == nil {
-			log.Printf("❌ ERROR: Source block %d not found for transaction %s", source, transactionID)
+
+		executionTime := time.Since(startTime).Seconds() * 1000 // ms
+
+		if err != nil {
+			outcome := OutcomeAborted
+			var budget *BudgetError
+			if errors.As(err, &budget) {
+				outcome = OutcomeBudgetExceeded
+			}
+			log.Printf("⚠️ Transaction %s %s after %d attempts: %v", transactionID, outcome, attempts, err)
+			txWAL.Abort(transactionID, outcome, err.Error())
 			TransactionMu.Lock()
-			transactionStatus[transactionID] = "failed"
+			transactionStatus[transactionID] = outcome
 			TransactionMu.Unlock()
+
+			recordTransactionLog(TransactionLog{
+				TxID:      transactionID,
+				Source:    source,
+				Target:    target,
+				Message:   data,
+				Type:      typeLabel,
+				ExecTime:  executionTime,
+				Timestamp: time.Now().Format(time.RFC3339),
+				Attempts:  attempts,
+				Outcome:   outcome,
+				Policy:    string(opts.DeadlockPolicy),
+				Mode:      crossShardMode,
+				Conflict:  opts.ConflictPolicy.Name(),
+			})
 			return
 		}
 
-		executionTime := time.Since(startTime).Seconds() * 1000 // ms
+		// The world state holds the transfer; from here a restart finishes it
+		committed := Transaction{
+			TransactionID: transactionID,
+			TxOrigin:      opts.Origin,
+			Source:        source,
+			Target:        target,
+			Version:       version,
+			Data:          data,
+			Status:        "completed",
+			Type:          typeLabel,
+			ExecTime:      executionTime,
+			ReadSet:       readSet,
+			WriteSet:      writeSet,
+			Ops:           ops,
+			Priority:      opts.Priority,
+			Attempts:      attempts,
+			Usage:         usage,
+			SignerKeyID:   opts.Signer.KeyID,
+			Signature:     opts.Signer.Signature,
+			Outcome:       OutcomeCommitted,
+			Timestamp:     time.Now().Format(time.RFC3339),
+		}
+		txWAL.Append(walRecord{Stage: walCommit, Tx: committed})
+
+		// In receipts mode the transfer is final once the target consumed it
+		var receiptLatency float64
+		if useReceipts {
+			waitStart := time.Now()
+			if credit := receiptQueue.Wait(transactionID); credit > 0 {
+				committed.DebitVersion = version // The write set was committed here
+				version = credit
+			}
+			receiptLatency = time.Since(waitStart).Seconds() * 1000 // ms
+		}
 
 		// Simulate propagation delay based on shard distance
 		var propagationLatency float64
@@ -218,40 +447,40 @@ func processTransaction(transactionID string, source int, target int, data strin
 		} else {
 			propagationLatency = float64(40 + rand.Intn(30)) // Non-sharded: 40–70ms
 		}
-		
 
 		// Simulate consensus delay (e.g., 2–4 validators * 30ms)
 		consensusDelay := float64((2 + rand.Intn(3)) * 30) // 60–120 ms
 
 		// Finality = Execution + Consensus + Propagation
-		finalityTime := executionTime + consensusDelay + propagationLatency
+		finalityTime := executionTime + consensusDelay + propagationLatency + receiptLatency
 
 		log.Printf("🕒 Finality time for %s: %.2f ms (Exec: %.2f + Consensus: %.2f + Propagation: %.2f)",
 			transactionID, finalityTime, executionTime, consensusDelay, propagationLatency)
 
-
-		// Update block with transaction
+		// Record the transaction in the open block, unless a rollback undid
+		// it while it was running
+		clock := hybridClock.Now()
+		BlockchainMu.Lock()
 		TransactionMu.Lock()
-		sourceBlock.Transactions = append(sourceBlock.Transactions, Transaction{
-			TransactionID: transactionID,
-			Source:        source,
-			Target:        target,
-			Data:          data,
-			Status:        "completed",
-			Type:          typeLabel,
-			ExecTime:      executionTime,
-			Propagation:   propagationLatency,
-			//TPS: 			tps,
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+		if transactionStatus[transactionID] == statusReverted {
+			TransactionMu.Unlock()
+			BlockchainMu.Unlock()
+			log.Printf("⏪ Transaction %s was reverted while executing", transactionID)
+			return
+		}
+		committed.HLC = clock
+		committed.Version = version
+		committed.Propagation = propagationLatency
+		committed.Timestamp = time.Now().Format(time.RFC3339)
+		blockProducer.add(committed)
 		transactionStatus[transactionID] = "completed"
 		TransactionMu.Unlock()
+		BlockchainMu.Unlock()
 
 		tps := 1000.0 / executionTime
 		tps = math.Round(tps*100) / 100 // Optional rounding
 
-		// save to firebase context
-		SaveTransactionToFirestore(TransactionLog{
+		recordTransactionLog(TransactionLog{
 			TxID:        transactionID,
 			Source:      source,
 			Target:      target,
@@ -261,29 +490,33 @@ func processTransaction(transactionID string, source int, target int, data strin
 			Finality:    finalityTime,
 			Propagation: propagationLatency,
 			Timestamp:   time.Now().Format(time.RFC3339),
+			HLC:         clock,
 			TPS:         tps,
+			Attempts:    attempts,
+			Outcome:     OutcomeCommitted,
+			Policy:      string(opts.DeadlockPolicy),
+			Mode:        crossShardMode,
+			Conflict:    opts.ConflictPolicy.Name(),
 		})
 
-		transactionLogsMu.Lock()
-		// Log to global transaction history
-		transactionLogs = append(transactionLogs, TransactionLog{
-			TxID:        transactionID,
-			Source:      source,
-			Target:      target,
-			Message:     data,
-			Type:        typeLabel,
-			ExecTime:    executionTime,
-			Finality:    finalityTime,
-			Propagation: propagationLatency,
-			Timestamp:   time.Now().Format(time.RFC3339),
-			TPS:         tps, // ✅ This was missing
-		})
+		log.Printf("✅ Transaction %s completed: Block %d → Block %d (Type: %s | Exec Time: %.3f ms | Attempts: %d)",
+			transactionID, source, target, typeLabel, executionTime, attempts)
+	}()
+}
 
-		transactionLogsMu.Unlock()
+// Save a transaction log to the store and the in-memory history
+func recordTransactionLog(entry TransactionLog) {
+	if entry.HLC.IsZero() {
+		entry.HLC = hybridClock.Now()
+	}
+	if err := store.SaveTransaction(entry); err != nil {
+		log.Printf("❌ Failed to write transaction to the %s store: %v", store.Name(), err)
+	}
 
-		log.Printf("✅ Transaction %s completed: Block %d → Block %d (Type: %s | Exec Time: %.3f ms)",
-			transactionID, source, target, typeLabel, executionTime)
-	}()
+	// Log to global transaction history
+	transactionLogsMu.Lock()
+	transactionLogs = append(transactionLogs, entry)
+	transactionLogsMu.Unlock()
 }
 
 // Process running Docker containers and add them to the blockchain
@@ -341,6 +574,7 @@ func getTransactionLogs(c *gin.Context) {
 	if transactionLogs == nil {
 		transactionLogs = []TransactionLog{}
 	}
+	sortLogsByHLC(transactionLogs) // Entries are appended after commit, not in clock order
 
 	// Log transaction count & contents
 	log.Printf("📜 Fetching transaction logs: %d entries", len(transactionLogs))
@@ -349,7 +583,12 @@ func getTransactionLogs(c *gin.Context) {
 	}
 
 	//  Send JSON response
-	c.JSON(http.StatusOK, gin.H{"logs": transactionLogs})
+	c.JSON(http.StatusOK, gin.H{
+		"logs":        transactionLogs,
+		"retry_stats": summariseRetries(transactionLogs),
+		"abort_rates": summariseAbortRates(transactionLogs),
+		"mode_stats":  summariseCrossShardModes(transactionLogs),
+	})
 }
 
 // Start the REST API server
@@ -358,15 +597,27 @@ func runAPIServer(cli *client.Client) {
 	r.GET("/", listRoutes)
 
 	r.Use(CORSMiddleware()) // Enable CORS
+	r.Use(GenesisMiddleware())
+	r.Use(HLCMiddleware())
 	// API Endpoints
 	r.GET("/allTransactions", getTransactionLogs)
 	r.GET("/blockchain", getBlockchain)
 	r.GET("/blockchain/shard", getShardBlockchain)
 	r.GET("/transactionStatus/:transactionID", checkTransactionStatus)
 	r.GET("/conflicts", getConflicts)
+	r.GET("/state", getWorldState)
+	r.GET("/receipts", getReceipts)
+	r.GET("/verify", verifyChainHandler)
+	r.GET("/transactions/:id/proof", getTransactionProof)
+	r.GET("/keys", getSigningKeys)
+	r.GET("/shards/:id/chain", getShardChain)
+	r.GET("/beacon", getBeaconChain)
+	r.GET("/genesis", getGenesis)
+	r.GET("/restore", getRestoreReport)
 	r.GET("/transactionLogs", getTransactionLogs)
 
 	r.GET("/executionOptions", getExecutionOptions)
+	r.GET("/budgets", getBudgets)
 	r.POST("/executeTransaction", executeTransaction)
 	r.POST("/resetBlockchain", resetBlockchainHandler)
 	r.POST("/deadlocksim", simulateDeadlockHandler)
@@ -380,6 +631,7 @@ func runAPIServer(cli *client.Client) {
 	r.POST("/assignNodesToShard", assignNodesToShardHandler)
 	r.POST("/shardTransactions", shardTransactionsHandler)
 	r.DELETE("/removeLastBlock", removeLastBlock)
+	r.POST("/rollback", rollbackHandler)
 
 	// Performance measurements
 	r.GET("/metrics/tps", func(c *gin.Context) {
@@ -387,6 +639,7 @@ func runAPIServer(cli *client.Client) {
 		defer txCountMutex.Unlock()
 		c.JSON(http.StatusOK, gin.H{"tps": currentTPS})
 	})
+	r.GET("/metrics/store", getStoreMetrics)
 
 	// Start Server
 	srv := &http.Server{
@@ -419,11 +672,20 @@ func listRoutes(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"endpoints": []string{
 			"/executionOptions",
+			"/budgets",
 			"/executeTransaction",
 			"/blockchain",
 			"/blockchain/shard",
 			"/transactionStatus/:transactionID",
 			"/conflicts",
+			"/state",
+			"/receipts",
+			"/verify",
+			"/transactions/:id/proof",
+			"/keys",
+			"/shards/:id/chain",
+			"/beacon",
+			"/genesis",
 			"/transactionLogs",
 			"/resetBlockchain",
 			"/addShardedTransactionHandler",
@@ -436,6 +698,7 @@ func listRoutes(c *gin.Context) {
 			"/assignNodesToShard",
 			"/shardTransactions",
 			"/removeLastBlock",
+			"/rollback",
 			"/getTransactionStatus",
 		},
 	})
@@ -443,10 +706,16 @@ func listRoutes(c *gin.Context) {
 
 func addShardedTransactionHandler(c *gin.Context) {
 	var reqBody struct {
-		SourceBlock int    `json:"source"`
-		TargetBlock int    `json:"target"`
-		Data        string `json:"data"`
-		Type        string `json:"type"`
+		SourceBlock    int    `json:"source"`
+		TargetBlock    int    `json:"target"`
+		Data           string `json:"data"`
+		Type           string `json:"type"`
+		CrossShardMode string `json:"cross_shard_mode"`
+		ConflictPolicy string `json:"conflict_policy"`
+		Priority       int    `json:"priority"`
+		TransactionID  string `json:"transaction_id"` // Optional; derived from the content if empty
+		SignedRequest
+		TxOrigin
 	}
 	// Parse and validate request
 	if err := c.ShouldBindJSON(&reqBody); err != nil {
@@ -458,26 +727,48 @@ func addShardedTransactionHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target block cannot be the same"})
 		return
 	}
+	if rejectOverBudget(c, reqBody.Data) {
+		return
+	}
+
+	opts, err := execOptionsFromRequest("", reqBody.CrossShardMode, reqBody.ConflictPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Priority = reqBody.Priority
+	if err := reqBody.verify(reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, reqBody.Nonce); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Signer = reqBody.SignedRequest
+	opts.Origin = newTxOrigin(reqBody.TxOrigin, reqBody.SignedRequest)
 
-	transactionID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
+	transactionID, err := resolveTxID(reqBody.TransactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, opts.Origin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Store transaction as pending
-	TransactionMu.Lock()
-	transactionStatus[transactionID] = "pending"
-	TransactionMu.Unlock()
+	// Store transaction as pending, unless it was already submitted
+	if status, ok := claimTransaction(transactionID); !ok {
+		rejectDuplicate(c, transactionID, status)
+		return
+	}
 
 	isSharded := reqBody.Type == "sharded"
 
 	// Process transaction asynchronously
-	go processTransaction(transactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, isSharded)
+	go processTransaction(transactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, isSharded, opts)
 
 	log.Printf("Sharded Transaction being added -> Source: %d | Target: %d | Data: %s", reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data)
 
 	// Return response immediately
 	c.JSON(http.StatusAccepted, gin.H{
-		"message":       "Sharded transaction submitted for processing",
-		"transactionID": transactionID,
-		"status":        "pending",
+		"message":          "Sharded transaction submitted for processing",
+		"transactionID":    transactionID,
+		"status":           "pending",
+		"cross_shard_mode": opts.CrossShardMode,
 	})
 }
 
@@ -496,13 +787,17 @@ func assignNodesToShardHandler(c *gin.Context) {
 		return
 	}
 	// Assign selected nodes to the specified shard
+	BlockchainMu.Lock()
 	for i := range Blockchain {
 		for _, nodeID := range reqBody.Nodes {
 			if Blockchain[i].Index == nodeID {
+				recordShardAssignment(nodeID, Blockchain[i].ShardID, reqBody.ShardID)
 				Blockchain[i].ShardID = reqBody.ShardID
 			}
 		}
 	}
+	BlockchainMu.Unlock()
+	ledgerStore.Changed()
 	log.Printf("✅ Assigned nodes %v to Shard %d", reqBody.Nodes, reqBody.ShardID)
 	c.JSON(http.StatusOK, gin.H{"message": "Nodes assigned to shard successfully"})
 }
@@ -556,7 +851,7 @@ func addBlockHandler(c *gin.Context) {
 			return
 		}
 	}
-	addBlock(reqBody.ContainerID)
+	appendBlock(reqBody.ContainerID, getShardID(reqBody.ContainerID))
 	totalBlocks := len(Blockchain)
 	BlockchainMu.Unlock()
 
@@ -571,10 +866,17 @@ func addBlockHandler(c *gin.Context) {
 // Add a single transaction
 func addTransactionHandler(c *gin.Context) {
 	var reqBody struct {
-		SourceBlock int    `json:"source"`
-		TargetBlock int    `json:"target"`
-		Data        string `json:"data"`
-		IsSharded   bool   `json:"is_sharded"`
+		SourceBlock    int    `json:"source"`
+		TargetBlock    int    `json:"target"`
+		Data           string `json:"data"`
+		IsSharded      bool   `json:"is_sharded"`
+		ConflictPolicy string `json:"conflict_policy"`
+		Priority       int    `json:"priority"`
+		Commutative    bool   `json:"commutative"`
+		Floor          *int64 `json:"floor"`
+		TransactionID  string `json:"transaction_id"` // Optional; derived from the content if empty
+		SignedRequest
+		TxOrigin
 	}
 
 	if err := c.ShouldBindJSON(&reqBody); err != nil {
@@ -586,16 +888,37 @@ func addTransactionHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Source and target block cannot be the same"})
 		return
 	}
+	if rejectOverBudget(c, reqBody.Data) {
+		return
+	}
 
-	transactionID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
+	opts, err := execOptionsFromRequest("", "", reqBody.ConflictPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Priority = reqBody.Priority
+	opts.Commutative, opts.Floor = reqBody.Commutative, reqBody.Floor
+	if err := reqBody.verify(reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, reqBody.Nonce); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Signer = reqBody.SignedRequest
+	opts.Origin = newTxOrigin(reqBody.TxOrigin, reqBody.SignedRequest)
 
-	TransactionMu.Lock()
-	transactionStatus[transactionID] = "pending"
-	TransactionMu.Unlock()
+	transactionID, err := resolveTxID(reqBody.TransactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, opts.Origin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if status, ok := claimTransaction(transactionID); !ok {
+		rejectDuplicate(c, transactionID, status)
+		return
+	}
 
 	isSharded := reqBody.IsSharded // ✅ Use the frontend’s instruction
 
-	go processTransaction(transactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, isSharded)
+	go processTransaction(transactionID, reqBody.SourceBlock, reqBody.TargetBlock, reqBody.Data, isSharded, opts)
 
 	log.Printf("Transaction being added -> Source: %d | Target: %d | Sharded: %v", reqBody.SourceBlock, reqBody.TargetBlock, isSharded)
 
@@ -618,44 +941,56 @@ func addParallelTransactionsHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No transactions provided"})
 		return
 	}
-	var wg sync.WaitGroup
-	transactionIDs := make([]string, 0)
-	mu := sync.Mutex{}
-	for _, tx := range transactions {
-		wg.Add(1)
-		go func(tx Transaction) {
-			if tx.Source == tx.Target {
-				log.Printf("❌ Skipping self-node transaction: %d → %d", tx.Source, tx.Target)
-				return
-			}
 
-			defer wg.Done()
-
-			transactionID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
-			TransactionMu.Lock()
-			transactionStatus[transactionID] = "pending"
-			TransactionMu.Unlock()
-
-			isSharded := getShardID(fmt.Sprintf("%d", tx.Source)) != getShardID(fmt.Sprintf("%d", tx.Target))
-
-			mu.Lock()
-			transactionIDs = append(transactionIDs, transactionID)
-			mu.Unlock()
-			// Process in a separate Goroutine
-			go processTransaction(transactionID, tx.Source, tx.Target, tx.Data, isSharded)
-
-		}(tx)
+	// Submission order is the serial order the batch must be equivalent to
+	batch := make([]BatchTx, 0, len(transactions))
+	for _, tx := range transactions {
+		if tx.Source == tx.Target {
+			log.Printf("❌ Skipping self-node transaction: %d → %d", tx.Source, tx.Target)
+			continue
+		}
+		if err := checkTxBudget(ResourceUsage{PayloadBytes: len(tx.Data)}); err != nil {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("transaction %d → %d: %v", tx.Source, tx.Target, err), "status": statusBudgetExceeded})
+			return
+		}
+		signer := SignedRequest{KeyID: tx.SignerKeyID, Signature: tx.Signature}
+		if err := signer.verify(tx.Source, tx.Target, tx.Data, tx.Nonce); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("transaction %d → %d: %v", tx.Source, tx.Target, err)})
+			return
+		}
+		origin := newTxOrigin(tx.TxOrigin, signer)
+		transactionID, err := resolveTxID(tx.TransactionID, tx.Source, tx.Target, tx.Data, origin)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("transaction %d → %d: %v", tx.Source, tx.Target, err)})
+			return
+		}
+		batch = append(batch, BatchTx{
+			TransactionID: transactionID,
+			Source:        tx.Source,
+			Target:        tx.Target,
+			Data:          tx.Data,
+			IsSharded:     getShardID(fmt.Sprintf("%d", tx.Source)) != getShardID(fmt.Sprintf("%d", tx.Target)),
+			Signer:        signer,
+			Origin:        origin,
+			Priority:      tx.Priority,
+		})
 	}
-	wg.Wait()
+
+	batch, duplicates := claimBatch(batch)
+	transactionIDs := submitBatch(batch, defaultExecOptions())
 	c.JSON(http.StatusAccepted, gin.H{
 		"message":        "Parallel transactions are being processed",
 		"transactionIDs": transactionIDs,
+		"duplicates":     duplicates,
 	})
 }
 
 func shardTransactionsHandler(c *gin.Context) {
 	var req struct {
-		Transactions []ShardedTransaction `json:"transactions"`
+		Transactions   []ShardedTransaction `json:"transactions"`
+		CrossShardMode string               `json:"cross_shard_mode"`
+		ConflictPolicy string               `json:"conflict_policy"`
+		Priority       int                  `json:"priority"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -668,62 +1003,130 @@ func shardTransactionsHandler(c *gin.Context) {
 		return
 	}
 
-	var wg sync.WaitGroup
-	transactionIDs := make([]string, 0)
-	mu := sync.Mutex{}
+	opts, err := execOptionsFromRequest("", req.CrossShardMode, req.ConflictPolicy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.Priority = req.Priority
 
-	// Iterate over the transactions
+	// Expand every source × target pair, in submission order
+	batch := make([]BatchTx, 0)
 	for _, tx := range req.Transactions {
-		txCopy := tx // Avoid race conditions
-
-		wg.Add(1)
-		go func(txCopy ShardedTransaction) {
-			defer wg.Done()
-
-			for _, src := range txCopy.Source {
-				for _, tgt := range txCopy.Target {
-					if src == tgt {
-						log.Printf("❌ Skipping self-node sharded transaction: %d → %d", src, tgt)
-						continue
-					}
-
-					transactionID := fmt.Sprintf("tx-%d", time.Now().UnixNano())
-
-					TransactionMu.Lock()
-					TransactionPool[transactionID] = &Transaction{
-						Source:        src,
-						Target:        tgt,
-						Data:          txCopy.Data,
-						TransactionID: transactionID,
-						Status:        "pending",
-					}
-					TransactionMu.Unlock()
-
-					// Ensure it's always sharded
-					isSharded := true
-					//isSharded := getShardID(fmt.Sprintf("%d", src)) != getShardID(fmt.Sprintf("%d", tgt))
-
-					// Store transaction ID safely
-					mu.Lock()
-					transactionIDs = append(transactionIDs, transactionID)
-					mu.Unlock()
-
-					// Process transaction asynchronously
-					go processTransaction(transactionID, src, tgt, txCopy.Data, isSharded)
+		if rejectOverBudget(c, tx.Data) {
+			return
+		}
+		signer := SignedRequest{KeyID: tx.SignerKeyID, Signature: tx.Signature}
+		if signer != (SignedRequest{}) && (len(tx.Source) != 1 || len(tx.Target) != 1) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A signed entry must have exactly one source and one target"})
+			return
+		}
+		for _, src := range tx.Source {
+			for _, tgt := range tx.Target {
+				if src == tgt {
+					log.Printf("❌ Skipping self-node sharded transaction: %d → %d", src, tgt)
+					continue
+				}
+				if err := signer.verify(src, tgt, tx.Data, tx.Nonce); err != nil {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("transaction %d → %d: %v", src, tgt, err)})
+					return
 				}
+				origin := newTxOrigin(tx.TxOrigin, signer)
+				batch = append(batch, BatchTx{
+					TransactionID: contentTxID(src, tgt, tx.Data, origin),
+					Source:        src,
+					Target:        tgt,
+					Data:          tx.Data,
+					IsSharded:     true, // Ensure it's always sharded
+					Signer:        signer,
+					Origin:        origin,
+					Priority:      opts.Priority,
+					Policy:        opts.ConflictPolicy,
+				})
 			}
-		}(txCopy) // Correctly passes copy
+		}
 	}
-
-	wg.Wait() // Ensure all goroutines finish before responding
+	batch, duplicates := claimBatch(batch)
+	// Cross-shard pairs run under the requested mode, 2PC or receipts, so
+	// their logs carry it for mode_stats; same-shard pairs run as a batch
+	transactionIDs := submitBatch(batch, opts)
 
 	// Return response
 	c.JSON(http.StatusAccepted, gin.H{
-		"message":        "Sharded transactions are being processed",
-		"transactionIDs": transactionIDs,
+		"message":          "Sharded transactions are being processed",
+		"transactionIDs":   transactionIDs,
+		"duplicates":       duplicates,
+		"cross_shard_mode": opts.CrossShardMode,
 	})
 }
 
+// Mark a batch pending and hand it to the parallel execution engine.
+// Transactions whose source block does not exist fail immediately.
+// Cross-shard transfers run on their own under opts' cross-shard protocol,
+// since the batch commits locally and would skip the coordinator.
+func submitBatch(batch []BatchTx, opts ExecOptions) []string {
+	transactionIDs := make([]string, 0, len(batch))
+	runnable := make([]BatchTx, 0, len(batch))
+	var crossShard []BatchTx
+	spansShards := make([]bool, len(batch))
+	for i, tx := range batch {
+		spansShards[i] = isCrossShard(tx.Source, tx.Target) // Takes BlockchainMu
+	}
+
+	BlockchainMu.Lock()
+	TransactionMu.Lock()
+	for i, tx := range batch {
+		transactionIDs = append(transactionIDs, tx.TransactionID)
+		if findBlockByIndex(tx.Source) == nil {
+			log.Printf("❌ ERROR: Source block %d not found for transaction %s", tx.Source, tx.TransactionID)
+			transactionStatus[tx.TransactionID] = "failed"
+			continue
+		}
+		transactionStatus[tx.TransactionID] = "pending"
+		if spansShards[i] {
+			crossShard = append(crossShard, tx)
+			continue
+		}
+		TransactionPool[tx.TransactionID] = &Transaction{
+			Source:        tx.Source,
+			Target:        tx.Target,
+			Data:          tx.Data,
+			TransactionID: tx.TransactionID,
+			Status:        "pending",
+		}
+		runnable = append(runnable, tx)
+	}
+	TransactionMu.Unlock()
+	BlockchainMu.Unlock()
+
+	for _, tx := range runnable {
+		txWAL.Submit(Transaction{
+			TransactionID: tx.TransactionID,
+			TxOrigin:      tx.Origin,
+			Source:        tx.Source,
+			Target:        tx.Target,
+			Data:          tx.Data,
+			Status:        "pending",
+			Type:          map[bool]string{true: "Sharded", false: "Non-Sharded"}[tx.IsSharded],
+			Priority:      tx.Priority,
+			SignerKeyID:   tx.Signer.KeyID,
+			Signature:     tx.Signer.Signature,
+		})
+	}
+	if len(runnable) > 0 {
+		go processBatch(runnable)
+	}
+	for _, tx := range crossShard {
+		txOpts := opts
+		txOpts.Signer, txOpts.Origin, txOpts.Priority = tx.Signer, tx.Origin, tx.Priority
+		if tx.Policy != nil {
+			txOpts.ConflictPolicy = tx.Policy
+		}
+		go processTransaction(tx.TransactionID, tx.Source, tx.Target, tx.Data, tx.IsSharded, txOpts)
+	}
+	return transactionIDs
+}
+
 func createShardHandler(c *gin.Context) {
 	var reqBody struct {
 		Nodes []int `json:"nodes"` // selected block indices
@@ -740,6 +1143,7 @@ func createShardHandler(c *gin.Context) {
 	}
 
 	// Step 1: Find the highest existing shard ID
+	BlockchainMu.Lock()
 	highestShard := 0
 	for _, block := range Blockchain {
 		if block.ShardID > highestShard {
@@ -752,10 +1156,13 @@ func createShardHandler(c *gin.Context) {
 	for i := range Blockchain {
 		for _, nodeID := range reqBody.Nodes {
 			if Blockchain[i].Index == nodeID {
+				recordShardAssignment(nodeID, Blockchain[i].ShardID, newShardID)
 				Blockchain[i].ShardID = newShardID
 			}
 		}
 	}
+	BlockchainMu.Unlock()
+	ledgerStore.Changed()
 
 	log.Printf("✅ Assigned nodes %v to NEW Shard %d", reqBody.Nodes, newShardID)
 	c.JSON(http.StatusOK, gin.H{
@@ -766,38 +1173,36 @@ func createShardHandler(c *gin.Context) {
 }
 
 func resetBlockchainHandler(c *gin.Context) {
+	BlockchainMu.Lock()
 	for i := range Blockchain {
+		recordShardAssignment(Blockchain[i].Index, Blockchain[i].ShardID, 0)
 		Blockchain[i].ShardID = 0 // Reset all nodes to one shard
 	}
+	BlockchainMu.Unlock()
+	ledgerStore.Changed()
 
 	log.Println("✅ Blockchain reset to single linear chain.")
 	c.JSON(http.StatusOK, gin.H{"message": "Blockchain reset successfully"})
 }
 
-// Fetch concurrency conflicts
-func getConflicts(c *gin.Context) {
-	conflictsMu.Lock()
-	defer conflictsMu.Unlock()
-
-	log.Printf("🔍 Fetching concurrency conflicts. Total: %d", len(concurrencyConflicts))
-	c.JSON(http.StatusOK, gin.H{
-		"total_conflicts": len(concurrencyConflicts),
-		"conflicts":       concurrencyConflicts,
-	})
-}
-
-// Remove the last block from the blockchain
+// Remove the last block from the blockchain by rolling back to the block
+// before it, so its transactions are reverted like any other rollback's.
+// ?requeue=true sends them back to the mempool.
 func removeLastBlock(c *gin.Context) {
-	if len(Blockchain) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Blockchain is empty, no blocks to remove"})
-		return
-	}
-
 	BlockchainMu.Lock()
-	Blockchain = Blockchain[:len(Blockchain)-1]
+	n := len(Blockchain)
 	BlockchainMu.Unlock()
+	if n <= 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only the genesis block is left, no blocks to remove"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Last block removed successfully"})
+	result, err := rollbackTo(n-1, c.Query("requeue") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Last block removed successfully", "rollback": result})
 }
 
 // Check if Docker is available
@@ -823,11 +1228,19 @@ func shutdownAPIServer() error {
 	return srv.Shutdown(ctx)
 }
 
-// Simulate a deadlock with two non-sharded transactions (dynamically chosen)
+// Simulate a deadlock with two non-sharded transactions (dynamically chosen).
+// Each transaction locks its own source block and then waits for the other's,
+// forming a cycle in the wait-for graph that the lock manager must break.
 func simulateDeadlockHandler(c *gin.Context) {
+	if rejectUnsignable(c) {
+		return
+	}
 	var req struct {
-		Source int `json:"source"`
-		Target int `json:"target"`
+		Source    int    `json:"source"`
+		Target    int    `json:"target"`
+		PriorityA int    `json:"priority_a"`
+		PriorityB int    `json:"priority_b"`
+		Policy    string `json:"policy"`
 	}
 
 	// Bind JSON body
@@ -841,95 +1254,165 @@ func simulateDeadlockHandler(c *gin.Context) {
 		return
 	}
 
-	var blockA, blockB *Block
+	policy := defaultDeadlockPolicy
+	if req.Policy != "" {
+		if err := policy.Set(req.Policy); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// Find selected blocks from Blockchain
 	BlockchainMu.Lock()
-	for i := range Blockchain {
-		if Blockchain[i].Index == req.Source {
-			blockA = &Blockchain[i]
-		} else if Blockchain[i].Index == req.Target {
-			blockB = &Blockchain[i]
-		}
-	}
+	found := findBlockByIndex(req.Source) != nil && findBlockByIndex(req.Target) != nil
 	BlockchainMu.Unlock()
 
-	if blockA == nil || blockB == nil {
+	if !found {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not find both source and target blocks in the blockchain."})
 		return
 	}
 
-	// Create two interlocked pending transactions to simulate deadlock
+	// Create two interlocked transactions; tx2 is the younger one
 	startTime1 := time.Now()
 	startTime2 := startTime1.Add(2 * time.Second)
 
 	tx1 := Transaction{
 		TransactionID: fmt.Sprintf("deadlock-tx-%d", time.Now().UnixNano()),
-		Source:        blockA.Index,
-		Target:        blockB.Index,
+		Source:        req.Source,
+		Target:        req.Target,
 		Data:          "Deadlock Tx A→B",
 		Type:          "Non-Sharded",
-		Status:        "pending", // Never completed
+		Status:        "pending",
+		Priority:      req.PriorityA,
 		Timestamp:     startTime1.Format(time.RFC3339),
 	}
 	tx2 := Transaction{
 		TransactionID: fmt.Sprintf("deadlock-tx-%d", time.Now().UnixNano()+1),
-		Source:        blockB.Index,
-		Target:        blockA.Index,
+		Source:        req.Target,
+		Target:        req.Source,
 		Data:          "Deadlock Tx B→A",
 		Type:          "Non-Sharded",
 		Status:        "pending",
+		Priority:      req.PriorityB,
 		Timestamp:     startTime2.Format(time.RFC3339),
 	}
 
-	// Store in global logs/status without marking completed
 	TransactionMu.Lock()
 	transactionStatus[tx1.TransactionID] = "pending"
 	transactionStatus[tx2.TransactionID] = "pending"
-
-	transactionLogs = append(transactionLogs,
-		TransactionLog{
-			TxID:      tx1.TransactionID,
-			Source:    tx1.Source,
-			Target:    tx1.Target,
-			Type:      "deadlock",
-			ExecTime:  0,
-			Timestamp: tx1.Timestamp,
-		},
-		TransactionLog{
-			TxID:      tx2.TransactionID,
-			Source:    tx2.Source,
-			Target:    tx2.Target,
-			Type:      "deadlock",
-			ExecTime:  0,
-			Timestamp: tx2.Timestamp,
-		},
-	)
-
 	TransactionMu.Unlock()
 
-	// Add pending transactions to respective blocks
-	BlockchainMu.Lock()
-	blockA.Transactions = append(blockA.Transactions, tx1)
-	blockB.Transactions = append(blockB.Transactions, tx2)
-	BlockchainMu.Unlock()
+	log.Printf("Deadlock simulation started between Block %d and Block %d (policy %s)", req.Source, req.Target, policy)
+
+	var holding, done sync.WaitGroup
+	holding.Add(2)
+	done.Add(2)
+	run := func(tx *Transaction, startedAt time.Time) {
+		defer done.Done()
+		lockManager.Begin(tx.TransactionID, startedAt, 2, tx.Priority, policy)
+		defer lockManager.Release(tx.TransactionID)
+
+		err := lockManager.Acquire(tx.TransactionID, tx.Source)
+		holding.Done()
+		if err == nil {
+			holding.Wait() // Both hold their first lock: the next requests deadlock
+			err = lockManager.Acquire(tx.TransactionID, tx.Target)
+		}
+		if err == nil {
+			err = lockManager.Prepare(tx.TransactionID)
+		}
+		if err == nil {
+			tx.ReadSet, tx.WriteSet = executeTransfer(worldState.Get, tx.Source, tx.Target, defaultTransferAmount)
+			tx.Version, err = worldState.Commit(CommitMeta{TxID: tx.TransactionID, Priority: tx.Priority, StartedAt: startedAt}, tx.ReadSet, tx.WriteSet)
+		}
+		tx.Attempts = 1
+		tx.ExecTime = float64(time.Since(startTime1).Microseconds()) / 1000
+		if err != nil {
+			log.Printf("💀 %s aborted: %v", tx.TransactionID, err)
+			tx.Status, tx.Outcome = "aborted", OutcomeAborted
+			return
+		}
+		tx.Status, tx.Outcome = "completed", OutcomeCommitted
+	}
+	go run(&tx1, startTime1)
+	go run(&tx2, startTime2)
+	done.Wait()
 
-	log.Printf("Deadlock simulation started between Block %d and Block %d", blockA.Index, blockB.Index)
+	for _, tx := range []Transaction{tx1, tx2} {
+		tx.HLC = hybridClock.Now()
+		BlockchainMu.Lock()
+		TransactionMu.Lock()
+		if transactionStatus[tx.TransactionID] == statusReverted {
+			tx.Status, tx.Outcome = statusReverted, OutcomeAborted
+		} else if tx.Outcome == OutcomeCommitted {
+			blockProducer.add(tx)
+		}
+		transactionStatus[tx.TransactionID] = tx.Status
+		TransactionMu.Unlock()
+		BlockchainMu.Unlock()
+
+		recordTransactionLog(TransactionLog{
+			TxID:      tx.TransactionID,
+			Source:    tx.Source,
+			Target:    tx.Target,
+			Message:   tx.Data,
+			Type:      "deadlock",
+			ExecTime:  tx.ExecTime,
+			Timestamp: tx.Timestamp,
+			HLC:       tx.HLC,
+			Attempts:  tx.Attempts,
+			Outcome:   tx.Outcome,
+			Policy:    string(policy),
+		})
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Deadlock simulation started between selected nodes.",
-		"tx1":     tx1,
-		"tx2":     tx2,
+		"message":       "Deadlock simulation resolved between selected nodes.",
+		"policy":        policy,
+		"victim_policy": lockManager.VictimPolicy,
+		"tx1":           tx1,
+		"tx2":           tx2,
 	})
 }
 
 // Main function
 func main() {
-	InitFirebase()                                    // initalise the firebase permanent storage
-	transactionLogs = LoadTransactionsFromFirestore() // load existing system
+	flag.Parse()
+	if err := loadGenesis(); err != nil {
+		log.Fatalf("❌ Failed to load genesis: %v", err)
+	}
+	initShards() // Ensure sharding system is initialized
+
+	switch flag.Arg(0) {
+	case "verify":
+		os.Exit(runVerifyCommand(flag.Args()[1:]))
+	case "sign":
+		os.Exit(runSignCommand(flag.Args()[1:]))
+	}
+	if err := keyRegistry.Load(); err != nil {
+		log.Fatalf("❌ Failed to load signing keys: %v", err)
+	}
+	var err error
+	store, err = openStore(storeBackend) // permanent storage
+	if err != nil {
+		log.Fatalf("❌ Failed to open the %s store: %v", storeBackend, err)
+	}
+	if _, err := restoreNode(); err != nil { // load existing system
+		log.Fatalf("❌ Refusing to start from the saved node state: %v", err)
+	}
+	recoverCoordinator() // finish cross-shard commits left in doubt
+	recoverReceipts()    // re-emit receipts that were never credited
 
 	// Start TPS monitoring in the background
 	go monitorTPS()
+	go runInDoubtResolver()
+	go blockProducer.Run()
+	go beaconChain.Run()
+	go ledgerStore.Run()
+	go conflictWriter.Run()
+	if lockManager.Detection == DetectPeriodic {
+		go lockManager.RunDetector()
+	}
 	// Create Docker client
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -973,6 +1456,10 @@ func main() {
 	if err := shutdownAPIServer(); err != nil {
 		log.Fatalf("❌ Error shutting down server: %v", err)
 	}
-	defer firestoreClient.Close() // gracefully close with app
+	if err := ledgerStore.Save(); err != nil {
+		log.Printf("❌ Failed to save ledger: %v", err)
+	}
+	conflictWriter.Flush()
+	defer store.Close() // gracefully close with app
 	log.Println("✅ Server shutdown complete.")
 }